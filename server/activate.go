@@ -9,7 +9,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-const minimumServerVersion = "5.10.0"
+const minimumServerVersion = "5.12.0"
 const botName = "rssfeedbot"
 const botDisplayName = "RSSFeed Plugin"
 const RSSFeedIconURL = "https://mattermost.gridprotectionalliance.org/plugins/rssfeed/images/rss.png"
@@ -27,8 +27,12 @@ func (p *RSSFeedPlugin) OnActivate() error {
 	if err := p.API.RegisterCommand(getCommand()); err != nil {
 		return errors.Wrap(err, "failed to register commands")
 	}
-	p.processHeartBeatFlag = true
-	go p.setupHeartBeat()
+	p.nodeID = model.NewId()
+
+	if err := p.migrateStore(); err != nil {
+		return errors.Wrap(err, "failed to migrate the stored subscriptions")
+	}
+	p.startScheduler()
 
 	p.API.LogDebug(fmt.Sprintf("Activated %s version %s", manifest.ID, manifest.Version))
 
@@ -36,7 +40,12 @@ func (p *RSSFeedPlugin) OnActivate() error {
 }
 
 func (p *RSSFeedPlugin) OnDeactivate() error {
-	p.processHeartBeatFlag = false
+	p.stopScheduler()
+
+	// let another node take over right away instead of waiting for the lease to expire
+	if err := p.unlock(pollerLockKey, p.nodeID); err != nil {
+		p.API.LogError("Failed to release the poller lease", "err", err.Error())
+	}
 	return nil
 }
 
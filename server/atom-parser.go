@@ -16,8 +16,37 @@ import (
 
 type AtomFeed struct {
 	atom.Feed
-	Icon      string `xml:"icon"`
-	Generator string `xml:"generator"`
+	Icon      string       `xml:"icon"`
+	Generator string       `xml:"generator"`
+	Entry     []*AtomEntry `xml:"entry"` // replaces atom.Feed.Entry, which has no categories
+}
+
+// AtomEntry - an entry with its categories
+type AtomEntry struct {
+	atom.Entry
+	Category []AtomCategory `xml:"category"`
+}
+
+// AtomCategory - term identifies the category, scheme the categorization scheme and label is for display
+type AtomCategory struct {
+	Term   string `xml:"term,attr"`
+	Scheme string `xml:"scheme,attr"`
+	Label  string `xml:"label,attr"`
+}
+
+// Categories - the categories of the entry, named by their label if they have one
+func (entry *AtomEntry) Categories() []ItemCategory {
+	categories := []ItemCategory{}
+	for _, category := range entry.Category {
+		name := strings.TrimSpace(category.Label)
+		if name == "" {
+			name = strings.TrimSpace(category.Term)
+		}
+		if name != "" {
+			categories = append(categories, ItemCategory{Name: name, Domain: category.Scheme})
+		}
+	}
+	return categories
 }
 
 // AtomParseString will be used to parse strings and will return the Atom object
@@ -36,16 +65,32 @@ func AtomParseString(s string) (*AtomFeed, error) {
 	return &feed, nil
 }
 
-// ItemsAfter - Get items that have been updated after timestamp
-func (feed *AtomFeed) ItemsAfter(timestamp int64) []*atom.Entry {
-	itemList := []*atom.Entry{}
+// AtomEntryFingerprint - identifies the entry in the seen item index by its id,
+// or its alternate link if it has none
+func AtomEntryFingerprint(entry *AtomEntry) string {
+	if entry.ID != "" {
+		return itemFingerprint(entry.ID)
+	}
+	if link := atomEntryLink(entry); link != "" {
+		return itemFingerprint(link)
+	}
+	return itemFingerprint(entry.Title)
+}
 
-	for _, item := range feed.Entry {
-		if AtomParseTimestamp(item.Updated) > timestamp {
-			itemList = append(itemList, item)
+// atomEntryLink - the alternate link of the entry, empty if it has none
+func atomEntryLink(entry *AtomEntry) string {
+	for _, link := range entry.Link {
+		if link.Rel == RelAlternate || link.Rel == "" {
+			return link.Href
 		}
 	}
-	return itemList
+	return ""
+}
+
+// AtomEntryRevision - changes whenever <updated>, the title or the link of the entry changes,
+// like every format the content is left out as it changes without the entry being edited
+func AtomEntryRevision(entry *AtomEntry) string {
+	return itemFingerprint(string(entry.Updated), entry.Title, atomEntryLink(entry))
 }
 
 // AtomParseTimestamp - turn an atom timestamp into a unix timestamp
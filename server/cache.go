@@ -0,0 +1,135 @@
+/*
+HTTP caching of feed fetches
+https://tools.ietf.org/html/rfc7234
+
+Responses are revalidated with If-None-Match and If-Modified-Since,
+and polls are skipped entirely while Cache-Control max-age or Expires say
+the previous response is still fresh.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// errCacheFresh is returned instead of fetching while the last response is still fresh,
+// nothing was fetched so it is neither a success nor a failure of the feed
+var errCacheFresh = errors.New("the last response is still fresh")
+
+// some servers send freshness lifetimes of days or years, cap them so feeds are still polled daily
+const maxCacheFreshness = 24 * time.Hour
+
+// CacheStats - how often a subscription's polls were answered from cache
+type CacheStats struct {
+	Requests    int64 // requests sent to the server
+	NotModified int64 // requests answered with 304 Not Modified
+	Fresh       int64 // polls skipped because the last response was still fresh
+	Shared      int64 // polls answered by another subscription's request for the same feed, see cycle.go
+}
+
+// Hits - polls that didn't have to download the feed
+func (s CacheStats) Hits() int64 {
+	return s.NotModified + s.Fresh + s.Shared
+}
+
+// Polls - all polls, including the ones that were skipped
+func (s CacheStats) Polls() int64 {
+	return s.Requests + s.Fresh + s.Shared
+}
+
+func (s CacheStats) String() string {
+	if s.Polls() == 0 {
+		return "no polls yet"
+	}
+	return fmt.Sprintf("%d of %d polls cached (%d%%)", s.Hits(), s.Polls(), s.Hits()*100/s.Polls())
+}
+
+// CacheValidators - the validators and the freshness of a response,
+// only stored on the subscription once the response was processed, see apply
+type CacheValidators struct {
+	ETag         string
+	LastModified string
+	CacheUntil   int64
+	MovedTo      string // the url the feed permanently redirected to, empty if it didn't
+}
+
+// responseValidators returns the validators and the freshness of a 200 or 304 response, nil for other responses
+func responseValidators(resp *http.Response, now time.Time) *CacheValidators {
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotModified {
+		return nil
+	}
+
+	validators := &CacheValidators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+	if lifetime := freshnessLifetime(resp.Header, now); lifetime > 0 {
+		if lifetime > maxCacheFreshness {
+			lifetime = maxCacheFreshness
+		}
+		validators.CacheUntil = now.Add(lifetime).Unix()
+	}
+	return validators
+}
+
+// apply stores the validators on the subscription, does nothing if there are none
+func (v *CacheValidators) apply(sub *Subscription) {
+	if v == nil {
+		return
+	}
+
+	if v.ETag != "" {
+		sub.ETag = v.ETag
+	}
+	if v.LastModified != "" {
+		sub.LastModified = v.LastModified
+	}
+	sub.CacheUntil = v.CacheUntil
+}
+
+// freshnessLifetime - how long the response may be used without asking the server again,
+// max-age takes precedence over Expires
+func freshnessLifetime(header http.Header, now time.Time) time.Duration {
+	age := time.Duration(0)
+	if seconds, err := strconv.ParseInt(header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+
+	for _, directive := range strings.Split(header.Get("Cache-Control"), ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		if directive == "no-cache" || directive == "no-store" {
+			return 0
+		}
+
+		if strings.HasPrefix(directive, "max-age=") {
+			seconds, err := strconv.ParseInt(strings.Trim(strings.TrimPrefix(directive, "max-age="), `"`), 10, 64)
+			if err != nil {
+				return 0
+			}
+			return time.Duration(seconds)*time.Second - age
+		}
+	}
+
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			// invalid dates, e.g. "0", mean already expired
+			return 0
+		}
+
+		date := now
+		if d, err := http.ParseTime(header.Get("Date")); err == nil {
+			date = d
+		}
+		return t.Sub(date)
+	}
+
+	return 0
+}
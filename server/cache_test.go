@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Date(2020, 4, 20, 12, 0, 0, 0, time.UTC)
+
+	header := http.Header{}
+	header.Set("Cache-Control", "public, max-age=600")
+	header.Set("Age", "100")
+	header.Set("Expires", "Mon, 20 Apr 2020 14:00:00 GMT")
+	assert.Equal(t, 500*time.Second, freshnessLifetime(header, now))
+
+	header = http.Header{}
+	header.Set("Expires", "Mon, 20 Apr 2020 13:00:00 GMT")
+	assert.Equal(t, time.Hour, freshnessLifetime(header, now))
+
+	header.Set("Date", "Mon, 20 Apr 2020 12:30:00 GMT")
+	assert.Equal(t, 30*time.Minute, freshnessLifetime(header, now))
+
+	header = http.Header{}
+	header.Set("Cache-Control", "no-cache, max-age=600")
+	assert.Equal(t, time.Duration(0), freshnessLifetime(header, now))
+
+	header = http.Header{}
+	header.Set("Expires", "0")
+	assert.Equal(t, time.Duration(0), freshnessLifetime(header, now))
+}
+
+func TestResponseValidators(t *testing.T) {
+	now := time.Date(2020, 4, 20, 12, 0, 0, 0, time.UTC)
+	sub := &Subscription{ETag: `"old"`}
+
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	resp.Header.Set("ETag", `"new"`)
+	resp.Header.Set("Last-Modified", "Mon, 20 Apr 2020 11:00:00 GMT")
+	resp.Header.Set("Cache-Control", "max-age=31536000")
+	responseValidators(resp, now).apply(sub)
+
+	assert.Equal(t, `"new"`, sub.ETag)
+	assert.Equal(t, "Mon, 20 Apr 2020 11:00:00 GMT", sub.LastModified)
+	assert.Equal(t, now.Add(maxCacheFreshness).Unix(), sub.CacheUntil)
+
+	resp = &http.Response{StatusCode: http.StatusInternalServerError, Header: http.Header{}}
+	resp.Header.Set("ETag", `"error"`)
+	assert.Nil(t, responseValidators(resp, now))
+	responseValidators(resp, now).apply(sub)
+	assert.Equal(t, `"new"`, sub.ETag)
+}
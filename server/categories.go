@@ -0,0 +1,136 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// how categories of items are shown, see the ShowCategories setting
+const (
+	CategoriesHidden   = "hidden"
+	CategoriesField    = "field"
+	CategoriesHashtags = "hashtags"
+)
+
+// hashtags shorter or longer than this aren't recognized by Mattermost
+const (
+	minHashtagLength = 3
+	maxHashtagLength = 50
+)
+
+// ItemCategory - a category of an item, Domain is the domain of an RSS category or the scheme of an Atom one
+type ItemCategory struct {
+	Name   string
+	Domain string
+}
+
+// categoryNames returns the names of the categories
+func categoryNames(categories []ItemCategory) []string {
+	names := make([]string, len(categories))
+	for i, category := range categories {
+		names[i] = category.Name
+	}
+	return names
+}
+
+// hashtag turns a category into a Mattermost hashtag: a letter followed by letters, digits, dashes,
+// underscores or dots, ending in a letter or digit. Other characters become underscores,
+// returns an empty string if nothing usable is left
+func hashtag(name string) string {
+	tag := strings.Builder{}
+	separated := false
+	for _, r := range strings.ToLower(name) {
+		switch {
+		case unicode.IsLetter(r):
+			// hashtags start with a letter
+		case tag.Len() == 0:
+			continue
+		case unicode.IsDigit(r), r == '-', r == '.', r == '_':
+		default:
+			separated = true
+			continue
+		}
+
+		if separated {
+			tag.WriteRune('_')
+			separated = false
+		}
+		tag.WriteRune(r)
+	}
+
+	result := tag.String()
+	if utf8.RuneCountInString(result) > maxHashtagLength {
+		result = string([]rune(result)[:maxHashtagLength])
+	}
+	result = strings.TrimRightFunc(result, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	if utf8.RuneCountInString(result) < minHashtagLength {
+		return ""
+	}
+	return "#" + result
+}
+
+// hashtags returns the distinct hashtags of the categories
+func hashtags(categories []ItemCategory) []string {
+	result := []string{}
+	seen := map[string]bool{}
+	for _, category := range categories {
+		tag := hashtag(category.Name)
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		result = append(result, tag)
+	}
+	return result
+}
+
+// showCategories adds the categories of the item to its attachment as a field,
+// hashtags go into the message of the post instead, see hashtagMessage
+func showCategories(item *FeedItem, config *configuration) {
+	if len(item.Fields.Categories) == 0 || config.ShowCategories != CategoriesField {
+		return
+	}
+
+	item.Attachment.Fields = append(item.Attachment.Fields, &model.SlackAttachmentField{
+		Title: "Categories",
+		Value: strings.Join(categoryNames(item.Fields.Categories), ", "),
+	})
+}
+
+// hashtagMessage returns the hashtags of the items for the message of their post,
+// hashtags in attachments aren't searchable
+func hashtagMessage(items []*FeedItem, config *configuration) string {
+	if config.ShowCategories != CategoriesHashtags {
+		return ""
+	}
+
+	categories := []ItemCategory{}
+	for _, item := range items {
+		categories = append(categories, item.Fields.Categories...)
+	}
+	return strings.Join(hashtags(categories), " ")
+}
+
+// addHashtags appends the hashtags of message missing from the post's message
+func addHashtags(post *model.Post, message string) {
+	for _, tag := range strings.Fields(message) {
+		if !containsWord(post.Message, tag) {
+			post.Message = strings.TrimSpace(post.Message + " " + tag)
+		}
+	}
+}
+
+func containsWord(text string, word string) bool {
+	for _, w := range strings.Fields(text) {
+		if w == word {
+			return true
+		}
+	}
+	return false
+}
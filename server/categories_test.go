@@ -0,0 +1,70 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHashtag(t *testing.T) {
+	for name, expected := range map[string]string{
+		"Kubernetes":                "#kubernetes",
+		"Cloud Native & Containers": "#cloud_native_containers",
+		"1.18 release":              "#release",
+		"node.js":                   "#node.js",
+		"C++":                       "",
+		"go":                        "",
+		"Über-Sicherheit!":          "#über-sicherheit",
+	} {
+		assert.Equal(t, expected, hashtag(name), name)
+	}
+
+	categories := []ItemCategory{{Name: "Kubernetes"}, {Name: "kubernetes"}, {Name: "Go"}, {Name: "Security"}}
+	assert.Equal(t, []string{"#kubernetes", "#security"}, hashtags(categories))
+}
+
+func TestParseCategories(t *testing.T) {
+	rss, err := RSSV2ParseString(`<rss version="2.0"><channel><item>
+		<title>a</title>
+		<category>Kubernetes</category>
+		<category domain="https://example.com/tags">Security</category>
+	</item></channel></rss>`)
+	assert.NoError(t, err)
+	assert.Equal(t, []ItemCategory{
+		{Name: "Kubernetes"},
+		{Name: "Security", Domain: "https://example.com/tags"},
+	}, rss.Channel.ItemList[0].Categories())
+
+	feed, err := AtomParseString(`<feed xmlns="http://www.w3.org/2005/Atom"><entry>
+		<title>a</title>
+		<category term="k8s" label="Kubernetes" scheme="https://example.com/tags"/>
+		<category term="security"/>
+	</entry></feed>`)
+	assert.NoError(t, err)
+	assert.Equal(t, "a", feed.Entry[0].Title)
+	assert.Equal(t, []ItemCategory{
+		{Name: "Kubernetes", Domain: "https://example.com/tags"},
+		{Name: "security"},
+	}, feed.Entry[0].Categories())
+}
+
+func TestShowCategories(t *testing.T) {
+	newItem := func() *FeedItem {
+		return &FeedItem{
+			Attachment: &model.SlackAttachment{},
+			Fields:     ItemFields{Categories: []ItemCategory{{Name: "Kubernetes"}, {Name: "Release Notes"}}},
+		}
+	}
+
+	item := newItem()
+	showCategories(item, &configuration{ShowCategories: CategoriesField})
+	assert.Equal(t, []*model.SlackAttachmentField{{Title: "Categories", Value: "Kubernetes, Release Notes"}}, item.Attachment.Fields)
+
+	// hashtags are only part of the message
+	item = newItem()
+	config := &configuration{ShowCategories: CategoriesHashtags}
+	showCategories(item, config)
+	assert.Empty(t, item.Attachment.Fields)
+	assert.Equal(t, "#kubernetes #release_notes", hashtagMessage([]*FeedItem{item}, config))
+}
@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	net_url "net/url"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 	"github.com/mattermost/mattermost-server/plugin"
@@ -14,7 +17,28 @@ import (
 const CommandHelp = `* |/feed sub [url]| - Connect your Mattermost channel to an rss feed 
 * |/feed list | - Lists the rss feeds you have subscribed to
 * |/feed unsub | - Opens the unsubscribe dialog
-* |/feed fetch | - Fetches the latest content from all the rss feeds`
+* |/feed fetch | - Fetches the latest content from all the rss feeds
+* |/feed import [url]| - Subscribes to every feed in an OPML file, uses the last OPML file you uploaded to the channel when no url is given
+* |/feed export| - Posts the subscriptions of this channel as an OPML file
+* |/feed resume [id]| - Resumes a subscription that was paused after failing too often
+* |/feed updates [id] [edit/repost/ignore]| - Sets whether changes to posted items edit the original post, are posted again or are ignored
+* |/feed interval [id] [minutes/default]| - Sets how often a feed is fetched, default adapts to how often the feed publishes
+* |/feed filter add [id] [include/exclude] [expression]| - Only posts items matching the expression, or drops them, e.g. |title:/CVE-\d+/ OR (category:security NOT "-rc")|
+* |/feed filter list [id]| - Lists the filters of a subscription
+* |/feed filter remove [id] [number]| - Removes a filter, the number is shown by |/feed filter list|
+* |/feed preview [id] [include/exclude] [expression]| - Shows which items of the feed would be posted with its filters, and the given one if any
+* |/feed template [id] [template/default]| - Renders items with a Go text/template, shows the current template if none is given
+* |/feed layout [id] [attachment/markdown/compact]| - Posts items as attachments, as searchable markdown messages or as one line per item with only its title, link and hashtags
+* |/feed thread [id] [off/feed/day/week]| - Posts items as replies to one post per feed, per day or per week instead of to the channel`
+
+// TemplateHelp documents what message templates see
+const TemplateHelp = `Templates are [Go text/templates](https://golang.org/pkg/text/template/) rendering the text of an item, they see:
+* |.Title|, |.Link|, |.Author|, |.Summary| (markdown) and |.Feed| (the title of the feed)
+* |.Published| - the time the item was published, zero if the feed doesn't say
+* |.Categories| - the names of the categories of the item
+* |.Enclosures| - files attached to the item, each with |.URL|, |.Type|, |.Title| and |.Length|
+* |.Updated| - whether the item changed after it was posted
+Functions: |message "New on" .Feed| adds a line to the message of the post, |field "Title" value| and |shortField "Title" value| add a field to the post, |truncate 200 .Summary|, |join .Categories ", "|, |hashtags .Categories| and |date "Jan 2, 2006" .Published|`
 
 // + `* |/feed initiate| - initiates the rss feed subscription poller`
 
@@ -24,7 +48,7 @@ func getCommand() *model.Command {
 		DisplayName:      "RSSFeed",
 		Description:      "Allows user to subscribe to an rss feed.",
 		AutoComplete:     true,
-		AutoCompleteDesc: "Available commands: list, sub, unsub, help, fetch",
+		AutoCompleteDesc: "Available commands: list, sub, unsub, help, fetch, import, export, resume, updates, interval, filter, preview, template, layout, thread",
 		AutoCompleteHint: "[command]",
 	}
 }
@@ -39,6 +63,31 @@ func getCommandPrivate(text string) *model.CommandResponse {
 	}
 }
 
+// parseSubscriptionID parses the id argument of a command, the response tells the user if it isn't one
+func parseSubscriptionID(param string) (uint32, *model.CommandResponse) {
+	id, err := strconv.ParseUint(param, 10, 32)
+	if err != nil {
+		return 0, getCommandPrivate("Argument is not a valid subscription id, see `/feed list`")
+	}
+	return uint32(id), nil
+}
+
+// canManageChannel is true if the user may change the properties of the channel,
+// which depends on whether the channel is public or private
+func (p *RSSFeedPlugin) canManageChannel(userID string, channelID string) bool {
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr != nil {
+		p.API.LogError("Failed to get channel", "channel_id", channelID, "err", appErr.Error())
+		return false
+	}
+
+	permission := model.PERMISSION_MANAGE_PRIVATE_CHANNEL_PROPERTIES
+	if channel.Type == model.CHANNEL_OPEN {
+		permission = model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES
+	}
+	return p.API.HasPermissionToChannel(userID, channelID, permission)
+}
+
 // ExecuteCommand will execute commands ...
 func (p *RSSFeedPlugin) ExecuteCommand(c *plugin.Context, args *model.CommandArgs) (*model.CommandResponse, *model.AppError) {
 	split := strings.Fields(args.Command)
@@ -68,6 +117,42 @@ func (p *RSSFeedPlugin) ExecuteCommand(c *plugin.Context, args *model.CommandArg
 		return p.handleUnsub(param, args), nil
 	case "fetch":
 		return p.handleFetch(param, args), nil
+	case "import":
+		return p.handleImport(param, args), nil
+	case "export":
+		return p.handleExport(param, args), nil
+	case "resume":
+		return p.handleResume(param, args), nil
+	case "updates":
+		mode := ""
+		if len(split) > 3 {
+			mode = split[3]
+		}
+		return p.handleUpdates(param, mode, args), nil
+	case "interval":
+		interval := ""
+		if len(split) > 3 {
+			interval = split[3]
+		}
+		return p.handleInterval(param, interval, args), nil
+	case "filter":
+		return p.handleFilter(split[2:], commandArgument(args.Command, 5), args), nil
+	case "preview":
+		return p.handlePreview(param, commandArgument(args.Command, 3), args), nil
+	case "layout":
+		layout := ""
+		if len(split) > 3 {
+			layout = split[3]
+		}
+		return p.handleLayout(param, layout, args), nil
+	case "thread":
+		mode := ""
+		if len(split) > 3 {
+			mode = split[3]
+		}
+		return p.handleThread(param, mode, args), nil
+	case "template":
+		return p.handleTemplate(param, commandArgument(args.Command, 3), args), nil
 	case "help":
 		text := "###### Mattermost RSSFeed Plugin - Slash Command Help\n" + strings.ReplaceAll(CommandHelp, "|", "`")
 		return getCommandPrivate(text), nil
@@ -94,9 +179,11 @@ func (p *RSSFeedPlugin) handleSub(param string, args *model.CommandArgs) *model.
 		return getCommandPrivate(fmt.Sprintf("Already Subscribed to [%s](%s)", sub.Title, sub.URL))
 	}
 
-	go p.subscribe(context.Background(), param, args.ChannelId, args.UserId)
+	p.goWork(func(ctx context.Context) {
+		p.subscribe(ctx, param, args.ChannelId, args.UserId)
+	})
 
-	p.createBotPost(fmt.Sprintf("Attempting to Subscribe to [url](%s)", param), args.ChannelId, args.UserId, nil)
+	p.createBotPost(fmt.Sprintf("Attempting to Subscribe to [url](%s)", param), args.ChannelId, args.UserId, "", nil)
 	return &model.CommandResponse{}
 }
 
@@ -106,7 +193,7 @@ func (p *RSSFeedPlugin) handleUnsub(param string, args *model.CommandArgs) *mode
 		return getCommandPrivate(err.Error())
 	}
 
-	p.createBotPost("", args.ChannelId, args.UserId, []*model.SlackAttachment{attachment})
+	p.createBotPost("", args.ChannelId, args.UserId, "", []*model.SlackAttachment{attachment})
 
 	return &model.CommandResponse{}
 }
@@ -114,20 +201,363 @@ func (p *RSSFeedPlugin) handleUnsub(param string, args *model.CommandArgs) *mode
 func (p *RSSFeedPlugin) handleFetch(param string, args *model.CommandArgs) *model.CommandResponse {
 	fetchURL := p.getURL() + "/fetch?channel=" + args.ChannelId
 	message := "Fetching Feeds in this channel, you can also trigger a fetch with: " + fetchURL
-	p.createBotPost(message, args.ChannelId, "", nil)
-	p.processChannel(args.ChannelId)
+	p.createBotPost(message, args.ChannelId, "", "", nil)
+	ctx, done, ok := p.beginWork(context.Background())
+	if !ok {
+		return getCommandPrivate(errDeactivating.Error())
+	}
+	defer done()
+
+	if err := p.processChannel(ctx, args.ChannelId, true); err != nil {
+		return getCommandPrivate(err.Error())
+	}
 	return &model.CommandResponse{}
 }
 
+func (p *RSSFeedPlugin) handleImport(param string, args *model.CommandArgs) *model.CommandResponse {
+	if param != "" && !IsURL(param) {
+		return getCommandPrivate("Argument is not a valid URL")
+	}
+
+	p.goWork(func(ctx context.Context) {
+		p.importOPML(ctx, param, args.ChannelId, args.UserId)
+	})
+
+	p.createBotPost("Importing OPML, this may take a while", args.ChannelId, args.UserId, "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleExport(param string, args *model.CommandArgs) *model.CommandResponse {
+	if err := p.exportOPML(args.ChannelId); err != nil {
+		p.API.LogError(err.Error())
+		return getCommandPrivate(fmt.Sprintf("Failed to export: `%s`", err.Error()))
+	}
+
+	exportURL := p.getURL() + "/export?channel=" + args.ChannelId
+	p.createBotPost("The export can also be downloaded from: "+exportURL, args.ChannelId, args.UserId, "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleResume(param string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	var title string
+	err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		title = sub.Title
+		sub.Paused = false
+		sub.Failures = 0
+		sub.LastError = ""
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	p.createBotPost(fmt.Sprintf("Resumed %s", title), args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleUpdates(param string, mode string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	if !isUpdateMode(mode) {
+		return getCommandPrivate(fmt.Sprintf("Updates must be one of `%s`, `%s` or `%s`", UpdateModeEdit, UpdateModeRepost, UpdateModeIgnore))
+	}
+
+	var title string
+	err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		title = sub.Title
+		sub.Updates = mode
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	p.createBotPost(fmt.Sprintf("Updates to items of %s will be handled with `%s`", title, mode), args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleInterval(param string, interval string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	minutes := 0
+	if interval != "default" {
+		var err error
+		minutes, err = strconv.Atoi(interval)
+		if err != nil || minutes < 1 {
+			return getCommandPrivate("Interval must be a number of minutes or `default`")
+		}
+	}
+
+	var title, schedule string
+	err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		title = sub.Title
+		sub.Interval = minutes
+		sub.scheduleNextFetch(time.Now(), p.getPollSettings())
+		schedule = sub.scheduleText(p.getPollSettings(), time.Now())
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	p.createBotPost(fmt.Sprintf("%s will be fetched %s", title, schedule), args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleFilter(params []string, expression string, args *model.CommandArgs) *model.CommandResponse {
+	if len(params) < 2 {
+		return getCommandPrivate("Usage: `/feed filter add|list|remove [id]`, see `/feed help`")
+	}
+
+	id, resp := parseSubscriptionID(params[1])
+	if resp != nil {
+		return resp
+	}
+
+	switch params[0] {
+	case "add":
+		mode := ""
+		if len(params) > 2 {
+			mode = params[2]
+		}
+		rule := &FilterRule{Mode: mode, Expression: expression}
+		if err := validateFilterRule(rule); err != nil {
+			return getCommandPrivate(fmt.Sprintf("Invalid filter: %s", err.Error()))
+		}
+
+		var title string
+		err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+			title = sub.Title
+			sub.Filters = append(sub.Filters, rule)
+			return nil
+		})
+		if err != nil {
+			return getCommandPrivate(err.Error())
+		}
+
+		p.createBotPost(fmt.Sprintf("Items of %s will be filtered: %s", title, rule.String()), args.ChannelId, "", "", nil)
+		return &model.CommandResponse{}
+
+	case "list":
+		subs, err := p.getSubscriptions(args.ChannelId)
+		if err != nil {
+			return getCommandPrivate(err.Error())
+		}
+		sub, _ := subs.findID(id)
+		if sub == nil {
+			return getCommandPrivate("id not found")
+		}
+		if len(sub.Filters) == 0 {
+			return getCommandPrivate(fmt.Sprintf("%s has no filters, all items are posted", sub.Title))
+		}
+
+		text := fmt.Sprintf("Filters of %s:\n", sub.Title)
+		for i, rule := range sub.Filters {
+			text += fmt.Sprintf("%d. %s\n", i+1, rule.String())
+		}
+		return getCommandPrivate(text)
+
+	case "remove":
+		number := 0
+		if len(params) > 2 {
+			number, _ = strconv.Atoi(params[2])
+		}
+
+		var title string
+		var removed *FilterRule
+		err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+			if number < 1 || number > len(sub.Filters) {
+				return errors.New("not a valid filter number, see `/feed filter list`")
+			}
+			title = sub.Title
+			removed = sub.Filters[number-1]
+			sub.Filters = append(sub.Filters[:number-1:number-1], sub.Filters[number:]...)
+			return nil
+		})
+		if err != nil {
+			return getCommandPrivate(err.Error())
+		}
+
+		p.createBotPost(fmt.Sprintf("Removed filter %s of %s", removed.String(), title), args.ChannelId, "", "", nil)
+		return &model.CommandResponse{}
+	}
+
+	return getCommandPrivate("Usage: `/feed filter add|list|remove [id]`, see `/feed help`")
+}
+
+func (p *RSSFeedPlugin) handlePreview(param string, filter string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	var extra *FilterRule
+	if filter != "" {
+		mode := strings.Fields(filter)[0]
+		extra = &FilterRule{Mode: mode, Expression: commandArgument(filter, 1)}
+		if err := validateFilterRule(extra); err != nil {
+			return getCommandPrivate(fmt.Sprintf("Invalid filter: %s", err.Error()))
+		}
+	}
+
+	ctx, done, ok := p.beginWork(context.Background())
+	if !ok {
+		return getCommandPrivate(errDeactivating.Error())
+	}
+	defer done()
+
+	text, err := p.previewFilters(ctx, args.ChannelId, id, extra)
+	if err != nil {
+		return getCommandPrivate(fmt.Sprintf("Failed to preview: `%s`", err.Error()))
+	}
+	return getCommandPrivate(text)
+}
+
+func (p *RSSFeedPlugin) handleLayout(param string, layout string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	if !isLayout(layout) {
+		return getCommandPrivate(fmt.Sprintf("Layout must be one of `%s`, `%s` or `%s`", LayoutAttachment, LayoutMarkdown, LayoutCompact))
+	}
+
+	var title string
+	err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		title = sub.Title
+		sub.Layout = layout
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	p.createBotPost(fmt.Sprintf("Items of %s will be posted with the `%s` layout", title, layout), args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleThread(param string, mode string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	if !isThreadMode(mode) {
+		return getCommandPrivate(fmt.Sprintf("Thread must be one of `%s`, `%s`, `%s` or `%s`", ThreadOff, ThreadFeed, ThreadDay, ThreadWeek))
+	}
+
+	var title string
+	err := p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		title = sub.Title
+		sub.Thread = mode
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	message := fmt.Sprintf("Items of %s will be posted as replies to a post per %s", title, mode)
+	switch mode {
+	case ThreadOff:
+		message = fmt.Sprintf("Items of %s will be posted to the channel", title)
+	case ThreadFeed:
+		message = fmt.Sprintf("Items of %s will be posted as replies to a single post", title)
+	}
+	p.createBotPost(message, args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+func (p *RSSFeedPlugin) handleTemplate(param string, text string, args *model.CommandArgs) *model.CommandResponse {
+	id, resp := parseSubscriptionID(param)
+	if resp != nil {
+		return resp
+	}
+
+	if !p.canManageChannel(args.UserId, args.ChannelId) {
+		return getCommandPrivate("You need permission to manage the channel to change how its feeds are posted")
+	}
+
+	subs, err := p.getSubscriptions(args.ChannelId)
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+	sub, _ := subs.findID(id)
+	if sub == nil {
+		return getCommandPrivate("id not found")
+	}
+
+	if text == "" {
+		current := sub.messageTemplate(p.getConfiguration())
+		if current == "" {
+			return getCommandPrivate(fmt.Sprintf("%s uses the default layout.\n%s", sub.Title, strings.ReplaceAll(TemplateHelp, "|", "`")))
+		}
+		return getCommandPrivate(fmt.Sprintf("Template of %s:\n```\n%s\n```\n%s", sub.Title, current, strings.ReplaceAll(TemplateHelp, "|", "`")))
+	}
+
+	if text == "default" {
+		text = ""
+	}
+
+	sample := ""
+	if text != "" {
+		sample, err = validateMessageTemplate(text, sub.Title)
+		if err != nil {
+			return getCommandPrivate(fmt.Sprintf("Invalid template: %s", err.Error()))
+		}
+	}
+
+	err = p.updateSubscription(args.ChannelId, id, func(sub *Subscription) error {
+		sub.Template = text
+		return nil
+	})
+	if err != nil {
+		return getCommandPrivate(err.Error())
+	}
+
+	if text == "" {
+		p.createBotPost(fmt.Sprintf("Items of %s will be posted with the default layout", sub.Title), args.ChannelId, "", "", nil)
+		return &model.CommandResponse{}
+	}
+	p.createBotPost(fmt.Sprintf("Items of %s will be posted with a template, for example:\n%s", sub.Title, sample), args.ChannelId, "", "", nil)
+	return &model.CommandResponse{}
+}
+
+// commandArgument returns the text of the command after its first n words, as typed
+func commandArgument(command string, n int) string {
+	rest := strings.TrimSpace(command)
+	for i := 0; i < n && rest != ""; i++ {
+		end := strings.IndexAny(rest, " \t\r\n")
+		if end == -1 {
+			return ""
+		}
+		rest = strings.TrimSpace(rest[end:])
+	}
+	return rest
+}
+
 func (p *RSSFeedPlugin) handleList(param string, args *model.CommandArgs) *model.CommandResponse {
 	hideURLs := p.getConfiguration().HideURLs
+	pollSettings := p.getPollSettings()
+	now := time.Now()
 	subs, err := p.getSubscriptions(args.ChannelId)
 	if err != nil {
 		return getCommandPrivate(err.Error())
 	}
 
 	if len(subs.Subscriptions) == 0 {
-		p.createBotPost("No subscriptions in this channel", args.ChannelId, args.UserId, nil)
+		p.createBotPost("No subscriptions in this channel", args.ChannelId, args.UserId, "", nil)
 		return &model.CommandResponse{}
 	}
 
@@ -147,6 +577,43 @@ func (p *RSSFeedPlugin) handleList(param string, args *model.CommandArgs) *model
 			Title: title,
 			Text:  fmt.Sprintf("Subscribed by: %s", username),
 			Color: sub.Color,
+			Fields: append([]*model.SlackAttachmentField{
+				{
+					Title: "ID",
+					Value: strconv.FormatUint(uint64(sub.ID), 10),
+					Short: true,
+				},
+				{
+					Title: "Cache",
+					Value: sub.CacheStats.String(),
+					Short: true,
+				},
+				{
+					Title: "Updates",
+					Value: sub.UpdateMode(),
+					Short: true,
+				},
+				{
+					Title: "Schedule",
+					Value: sub.scheduleText(pollSettings, now),
+					Short: true,
+				},
+				{
+					Title: "Layout",
+					Value: sub.PostLayout(),
+					Short: true,
+				},
+				{
+					Title: "Thread",
+					Value: sub.ThreadMode(),
+					Short: true,
+				},
+				{
+					Title: "Filters",
+					Value: strconv.Itoa(len(sub.Filters)),
+					Short: true,
+				},
+			}, healthFields(sub)...),
 		}
 	}
 
@@ -0,0 +1,12 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommandArgument(t *testing.T) {
+	assert.Equal(t, `include title:"a  b" OR c`, commandArgument(`/feed preview 123 include title:"a  b" OR c`, 3))
+	assert.Equal(t, "", commandArgument("/feed preview 123", 3))
+}
@@ -26,7 +26,21 @@ type configuration struct {
 	SortMessages    bool
 	GravatarDefault string
 	GravatarCustom  string
-	disabled        bool
+	MarkUpdates     bool
+	ReplyToUpdates  bool
+	ShowCategories  string
+	MessageTemplate string
+
+	MinPollInterval string
+	MaxPollInterval string
+
+	MaxConcurrentFetches string
+	MaxFetchesPerHost    string
+
+	FailureNotifyThreshold string
+	FailurePauseThreshold  string
+
+	disabled bool
 }
 
 // Clone shallow copies the configuration. Your implementation may require a deep copy if
@@ -36,6 +50,13 @@ func (c *configuration) Clone() *configuration {
 	return &clone
 }
 
+// pollingChanged is true if the settings that decide when feeds are polled differ
+func (c *configuration) pollingChanged(previous *configuration) bool {
+	return c.Heartbeat != previous.Heartbeat ||
+		c.MinPollInterval != previous.MinPollInterval ||
+		c.MaxPollInterval != previous.MaxPollInterval
+}
+
 // getConfiguration retrieves the active configuration under lock, making it safe to use
 // concurrently. The active configuration may change underneath the client of this method, but
 // the struct returned by this API call is considered immutable.
@@ -86,12 +107,28 @@ func (p *RSSFeedPlugin) OnConfigurationChange() error {
 		return errors.Wrap(err, "failed to load plugin configuration")
 	}
 
+	if configuration.MessageTemplate != "" {
+		// the rest of the configuration still applies, subscriptions fall back to the default layout
+		if _, err := validateMessageTemplate(configuration.MessageTemplate, "Example Feed"); err != nil {
+			p.API.LogError("Ignoring invalid message template", "err", err.Error())
+			configuration.MessageTemplate = ""
+		}
+	}
+
 	if configuration.GravatarDefault == "custom" {
 		configuration.GravatarDefault = url.QueryEscape(configuration.GravatarCustom)
 	}
 
+	previous := p.getConfiguration()
 	p.setConfiguration(configuration)
 
+	if configuration.pollingChanged(previous) {
+		p.recheckChannels()
+	}
+	if configuration.MaxConcurrentFetches != previous.MaxConcurrentFetches || configuration.MaxFetchesPerHost != previous.MaxFetchesPerHost {
+		p.resetFetchPool()
+	}
+
 	return nil
 }
 
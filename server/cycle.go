@@ -0,0 +1,262 @@
+/*
+Shared fetches within a heartbeat
+
+Channels subscribed to the same feed would each fetch and parse it on every heartbeat.
+The heartbeat carries a fetchCycle in its context, the first subscription to fetch a url
+does the request and every other subscription to the same url with the same cache validators
+waits for and reuses the response. Parsed documents are shared the same way, by the hash of the body.
+What is new is still decided by each subscription's own seen item index.
+
+Subscriptions to the same feed usually have different intervals, so they rarely come due together.
+Once one of them is due, the others in the channels read by the heartbeat are polled along with it,
+as the response is shared anyway. Channels with nothing due aren't read, see nextPollKey,
+their subscriptions to the feed keep to their own schedule.
+
+The heartbeat knows which subscriptions will poll each feed before polling, once the last of them
+is done the responses and documents of the feed are dropped instead of being kept for the whole heartbeat.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fetchCycle - the fetches and parsed documents of one heartbeat
+type fetchCycle struct {
+	lock   sync.Mutex
+	calls  map[string]*fetchCall
+	parsed map[string]*parseCall
+	due    map[string]bool       // normalized urls of the feeds due in this heartbeat
+	feeds  map[string]*cycleFeed // by normalized url, what to drop once the feed is done
+}
+
+// cycleFeed - the subscriptions yet to poll a feed and the shared results they may use
+type cycleFeed struct {
+	pending   int
+	fetchKeys map[string]bool
+	parseKeys map[string]bool
+}
+
+type fetchCall struct {
+	done     chan struct{}
+	body     string
+	resp     *http.Response
+	err      error
+	canceled bool // the context of the caller ended, see fetch
+}
+
+type parseCall struct {
+	once  sync.Once
+	feed  interface{}
+	err   error
+	feeds int // feeds sharing the document, e.g. mirrors
+}
+
+type fetchCycleKey struct{}
+
+func newFetchCycle() *fetchCycle {
+	return &fetchCycle{
+		calls:  map[string]*fetchCall{},
+		parsed: map[string]*parseCall{},
+		due:    map[string]bool{},
+		feeds:  map[string]*cycleFeed{},
+	}
+}
+
+// withFetchCycle returns a context sharing fetches and parsed documents until it is done
+func withFetchCycle(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fetchCycleKey{}, newFetchCycle())
+}
+
+// fetchCycleFrom returns the cycle of the context, nil if fetches aren't shared
+func fetchCycleFrom(ctx context.Context) *fetchCycle {
+	cycle, _ := ctx.Value(fetchCycleKey{}).(*fetchCycle)
+	return cycle
+}
+
+// markDue records the feeds of the due subscriptions
+func (c *fetchCycle) markDue(subs *SubscriptionList, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, sub := range subs.Subscriptions {
+		if sub.isDue(now) {
+			c.due[normalizeFeedURL(sub.URL)] = true
+		}
+	}
+}
+
+// expect counts the subscriptions of the list that will poll their feed in this heartbeat,
+// called once markDue saw every list
+func (c *fetchCycle) expect(subs *SubscriptionList, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, sub := range subs.Subscriptions {
+		feedURL := normalizeFeedURL(sub.URL)
+		if sub.isDue(now) || (!sub.Paused && c.due[feedURL]) {
+			c.getFeed(feedURL).pending++
+		}
+	}
+}
+
+// done is called by every subscription that polled feedURL, with the url it had before polling,
+// the shared results of the feed are dropped once no other subscription needs them
+func (c *fetchCycle) done(feedURL string) {
+	if c == nil {
+		return
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	feedURL = normalizeFeedURL(feedURL)
+	feed, ok := c.feeds[feedURL]
+	if !ok || feed.pending == 0 {
+		// not counted, kept until the heartbeat ends
+		return
+	}
+	feed.pending--
+	if feed.pending > 0 {
+		return
+	}
+
+	for key := range feed.fetchKeys {
+		delete(c.calls, key)
+	}
+	for key := range feed.parseKeys {
+		if call := c.parsed[key]; call != nil {
+			call.feeds--
+			if call.feeds == 0 {
+				delete(c.parsed, key)
+			}
+		}
+	}
+	delete(c.feeds, feedURL)
+}
+
+// getFeed returns the entry of the feed, c.lock must be held
+func (c *fetchCycle) getFeed(feedURL string) *cycleFeed {
+	feed, ok := c.feeds[feedURL]
+	if !ok {
+		feed = &cycleFeed{fetchKeys: map[string]bool{}, parseKeys: map[string]bool{}}
+		c.feeds[feedURL] = feed
+	}
+	return feed
+}
+
+// feedDue is true if another subscription to the feed of sub is due, false for paused subscriptions
+// and without a cycle
+func (c *fetchCycle) feedDue(sub *Subscription) bool {
+	if c == nil || sub.Paused {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.due[normalizeFeedURL(sub.URL)]
+}
+
+// fetch runs fetch once per fetchKey of sub, later callers wait for the first one and get its result,
+// shared is true if the result came from another caller.
+// A fetch that failed because the context of its caller ended isn't shared, the callers waiting for it fetch again.
+func (c *fetchCycle) fetch(ctx context.Context, sub *Subscription, fetch func() (string, *http.Response, error)) (string, *http.Response, bool, error) {
+	key := fetchKey(sub)
+
+	for {
+		c.lock.Lock()
+		call, shared := c.calls[key]
+		if !shared {
+			call = &fetchCall{done: make(chan struct{})}
+			c.calls[key] = call
+			c.getFeed(normalizeFeedURL(sub.URL)).fetchKeys[key] = true
+		}
+		c.lock.Unlock()
+
+		if !shared {
+			call.body, call.resp, call.err = fetch()
+			if call.err != nil && ctx.Err() != nil {
+				call.canceled = true
+				c.lock.Lock()
+				if c.calls[key] == call {
+					delete(c.calls, key)
+				}
+				c.lock.Unlock()
+			}
+			close(call.done)
+			return call.body, call.resp, false, call.err
+		}
+
+		<-call.done
+		if !call.canceled {
+			return call.body, call.resp, true, call.err
+		}
+	}
+}
+
+// parse runs parse once per format and document fetched for sub
+func (c *fetchCycle) parse(sub *Subscription, body string, parse func() (interface{}, error)) (interface{}, error) {
+	hash := sha256.Sum256([]byte(body))
+	key := sub.Format.String() + "\x00" + hex.EncodeToString(hash[:])
+
+	c.lock.Lock()
+	call, ok := c.parsed[key]
+	if !ok {
+		call = &parseCall{}
+		c.parsed[key] = call
+	}
+	feed := c.getFeed(normalizeFeedURL(sub.URL))
+	if !feed.parseKeys[key] {
+		feed.parseKeys[key] = true
+		call.feeds++
+	}
+	c.lock.Unlock()
+
+	call.once.Do(func() {
+		call.feed, call.err = parse()
+	})
+	return call.feed, call.err
+}
+
+// fetchKey - subscriptions with the same key get the same response
+func fetchKey(sub *Subscription) string {
+	return strings.Join([]string{normalizeFeedURL(sub.URL), sub.ETag, sub.LastModified}, "\x00")
+}
+
+// normalizeFeedURL lower cases scheme and host and drops default ports and fragments,
+// urls that only differ in these point at the same feed
+func normalizeFeedURL(feedURL string) string {
+	u, err := url.Parse(strings.TrimSpace(feedURL))
+	if err != nil || !u.IsAbs() {
+		return feedURL
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if strings.Contains(host, ":") {
+		// ipv6
+		host = "[" + host + "]"
+	}
+	port := u.Port()
+	if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+		port = ""
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	u.Fragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeFeedURL(t *testing.T) {
+	assert.Equal(t, "https://example.com/feed", normalizeFeedURL("HTTPS://Example.COM:443/feed#top"))
+	assert.Equal(t, "http://example.com:8080/", normalizeFeedURL(" http://example.com:8080"))
+	assert.Equal(t, "http://[::1]/feed", normalizeFeedURL("http://[::1]:80/feed"))
+	assert.Equal(t, "not a url", normalizeFeedURL("not a url"))
+}
+
+func TestFetchCycleSharesFetches(t *testing.T) {
+	cycle := fetchCycleFrom(withFetchCycle(context.Background()))
+	assert.Nil(t, fetchCycleFrom(context.Background()))
+
+	var requests int32
+	release := make(chan struct{})
+	fetch := func() (string, *http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		return "body", &http.Response{StatusCode: http.StatusOK}, nil
+	}
+
+	a := &Subscription{URL: "https://example.com/feed"}
+	b := &Subscription{URL: "https://EXAMPLE.com/feed#latest"}
+
+	wg := sync.WaitGroup{}
+	shared := make([]bool, 2)
+	for i, sub := range []*Subscription{a, b} {
+		wg.Add(1)
+		go func(i int, sub *Subscription) {
+			defer wg.Done()
+			body, _, s, err := cycle.fetch(context.Background(), sub, fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "body", body)
+			shared[i] = s
+		}(i, sub)
+	}
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), requests)
+	assert.NotEqual(t, shared[0], shared[1])
+
+	// other cache validators get their own request
+	c := &Subscription{URL: "https://example.com/feed", ETag: `"v2"`}
+	_, _, s, _ := cycle.fetch(context.Background(), c, fetch)
+	assert.False(t, s)
+	assert.Equal(t, int32(2), requests)
+}
+
+func TestFetchCycleDoesNotShareCanceledFetches(t *testing.T) {
+	cycle := newFetchCycle()
+	sub := &Subscription{URL: "https://example.com/feed"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	started := make(chan struct{})
+	canceled := make(chan error, 1)
+	go func() {
+		_, _, _, err := cycle.fetch(ctx, sub, func() (string, *http.Response, error) {
+			close(started)
+			<-ctx.Done()
+			return "", nil, ctx.Err()
+		})
+		canceled <- err
+	}()
+	<-started
+
+	waited := make(chan string, 1)
+	go func() {
+		body, _, shared, err := cycle.fetch(context.Background(), sub, func() (string, *http.Response, error) {
+			return "body", &http.Response{StatusCode: http.StatusOK}, nil
+		})
+		assert.NoError(t, err)
+		assert.False(t, shared)
+		waited <- body
+	}()
+
+	// the second caller waits for the first one
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	assert.Equal(t, context.Canceled, <-canceled)
+	assert.Equal(t, "body", <-waited)
+
+	// the successful fetch is shared
+	body, _, shared, err := cycle.fetch(context.Background(), sub, func() (string, *http.Response, error) {
+		return "", nil, assert.AnError
+	})
+	assert.NoError(t, err)
+	assert.True(t, shared)
+	assert.Equal(t, "body", body)
+}
+
+func TestFetchCycleFeedDue(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+	settings := PollSettings{Default: 15 * time.Minute}
+
+	// the same feed with another interval in another channel, not due yet
+	due := &Subscription{URL: "https://example.com/feed", NextFetch: now.Unix()}
+	later := &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://EXAMPLE.com/feed", NextFetch: now.Add(time.Hour).Unix(), Interval: 120},
+	}}
+	assert.False(t, later.needsPoll(settings, now, nil))
+
+	cycle := newFetchCycle()
+	cycle.markDue(&SubscriptionList{Subscriptions: []*Subscription{due}}, now)
+	assert.True(t, cycle.feedDue(later.Subscriptions[0]))
+	assert.True(t, later.needsPoll(settings, now, cycle))
+
+	later.Subscriptions[0].Paused = true
+	assert.False(t, cycle.feedDue(later.Subscriptions[0]))
+	assert.False(t, cycle.feedDue(&Subscription{URL: "https://example.com/other"}))
+}
+
+func TestFetchCycleDropsDoneFeeds(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+	a := &Subscription{URL: "https://example.com/feed", Format: FeedFormatRSSV2, NextFetch: now.Unix()}
+	b := &Subscription{URL: "https://EXAMPLE.com/feed", Format: FeedFormatRSSV2, NextFetch: now.Add(time.Hour).Unix()}
+	mirror := &Subscription{URL: "https://mirror.example.org/feed", Format: FeedFormatRSSV2, NextFetch: now.Unix()}
+	lists := []*SubscriptionList{
+		{Subscriptions: []*Subscription{a, mirror}},
+		{Subscriptions: []*Subscription{b}},
+	}
+
+	cycle := newFetchCycle()
+	for _, list := range lists {
+		cycle.markDue(list, now)
+	}
+	for _, list := range lists {
+		cycle.expect(list, now)
+	}
+
+	fetch := func() (string, *http.Response, error) {
+		return "body", &http.Response{StatusCode: http.StatusOK}, nil
+	}
+	parses := 0
+	parse := func() (interface{}, error) {
+		parses++
+		return &RSSV2{}, nil
+	}
+	for _, sub := range []*Subscription{a, mirror} {
+		_, _, _, err := cycle.fetch(context.Background(), sub, fetch)
+		assert.NoError(t, err)
+		_, err = cycle.parse(sub, "body", parse)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, parses)
+
+	// b still polls the feed
+	cycle.done(a.URL)
+	assert.Len(t, cycle.calls, 2)
+	assert.Len(t, cycle.parsed, 1)
+
+	// the mirror still uses the document
+	cycle.done(b.URL)
+	assert.Len(t, cycle.calls, 1)
+	assert.Len(t, cycle.parsed, 1)
+
+	cycle.done(mirror.URL)
+	assert.Empty(t, cycle.calls)
+	assert.Empty(t, cycle.parsed)
+	assert.Empty(t, cycle.feeds)
+
+	// subscriptions that weren't counted don't drop anything
+	var none *fetchCycle
+	none.done(a.URL)
+	cycle.done(a.URL)
+}
@@ -0,0 +1,111 @@
+/*
+Feed autodiscovery, when a user subscribes to a website instead of a feed
+the page is searched for <link rel="alternate"> tags pointing at feeds.
+
+https://www.rssboard.org/rss-autodiscovery
+*/
+
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// FeedLink - a feed advertised by an html page
+type FeedLink struct {
+	Title string
+	URL   string
+	Type  string
+}
+
+// feedLinkTypes - mime types of the feed formats that can be subscribed to
+var feedLinkTypes = map[string]bool{
+	"application/rss+xml":   true,
+	"application/atom+xml":  true,
+	"application/rdf+xml":   true,
+	"application/feed+json": true,
+}
+
+// FindFeedLinks - returns the feeds advertised in the html document,
+// relative hrefs are resolved against the document's <base href> or else base, the url the page was read from
+func FindFeedLinks(body string, base *url.URL) []*FeedLink {
+	links := []*FeedLink{}
+	seen := map[string]bool{}
+	baseSet := false
+
+	tokenizer := html.NewTokenizer(strings.NewReader(body))
+	for {
+		tokenType := tokenizer.Next()
+		if tokenType == html.ErrorToken {
+			// io.EOF or malformed html, either way return what was found
+			return links
+		}
+		if tokenType != html.StartTagToken && tokenType != html.SelfClosingTagToken {
+			continue
+		}
+
+		name, hasAttr := tokenizer.TagName()
+		if (string(name) != "link" && string(name) != "base") || !hasAttr {
+			continue
+		}
+
+		attrs := map[string]string{}
+		for more := true; more; {
+			var key, val []byte
+			key, val, more = tokenizer.TagAttr()
+			attrs[string(key)] = strings.TrimSpace(string(val))
+		}
+
+		if string(name) == "base" {
+			// only the first <base href> counts, it belongs in the head before any link
+			if href, err := url.Parse(attrs["href"]); err == nil && attrs["href"] != "" && !baseSet {
+				baseSet = true
+				if base != nil {
+					href = base.ResolveReference(href)
+				}
+				base = href
+			}
+			continue
+		}
+
+		if !isAlternateRel(attrs["rel"]) || !feedLinkTypes[strings.ToLower(attrs["type"])] || attrs["href"] == "" {
+			continue
+		}
+
+		href, err := url.Parse(attrs["href"])
+		if err != nil {
+			continue
+		}
+		if base != nil {
+			href = base.ResolveReference(href)
+		}
+
+		link := &FeedLink{
+			Title: attrs["title"],
+			URL:   href.String(),
+			Type:  strings.ToLower(attrs["type"]),
+		}
+		if seen[link.URL] {
+			continue
+		}
+		seen[link.URL] = true
+
+		if link.Title == "" {
+			link.Title = link.URL
+		}
+		links = append(links, link)
+	}
+}
+
+// rel is a space separated list of link types
+func isAlternateRel(rel string) bool {
+	for _, r := range strings.Fields(rel) {
+		if strings.EqualFold(r, RelAlternate) {
+			return true
+		}
+	}
+	return false
+}
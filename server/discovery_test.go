@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindFeedLinks(t *testing.T) {
+	body := `<!DOCTYPE html>
+<html>
+<head>
+	<link rel="stylesheet" href="/style.css">
+	<link rel="alternate" type="application/rss+xml" title="Posts" href="/feed.xml">
+	<link rel="alternate" type="application/atom+xml" href="https://other.example.com/atom">
+	<link rel="alternate" hreflang="de" href="/de/">
+	<link rel="alternate" type="application/rss+xml" href="feed.xml">
+</head>
+<body></body>
+</html>`
+
+	base, err := url.Parse("https://example.com/blog/")
+	require.NoError(t, err)
+
+	links := FindFeedLinks(body, base)
+	require.Len(t, links, 3)
+	assert.Equal(t, "https://example.com/feed.xml", links[0].URL)
+	assert.Equal(t, "Posts", links[0].Title)
+	assert.Equal(t, "https://other.example.com/atom", links[1].URL)
+	assert.Equal(t, links[1].URL, links[1].Title)
+	assert.Equal(t, "https://example.com/blog/feed.xml", links[2].URL)
+
+	assert.Empty(t, FindFeedLinks("<html></html>", base))
+}
+
+func TestFindFeedLinksWithBase(t *testing.T) {
+	body := `<html><head>
+	<base href="/static/">
+	<base href="https://ignored.example.com/">
+	<link rel="alternate" type="application/rss+xml" href="feed.xml">
+</head></html>`
+
+	base, err := url.Parse("https://example.com/blog/")
+	require.NoError(t, err)
+
+	links := FindFeedLinks(body, base)
+	require.Len(t, links, 1)
+	assert.Equal(t, "https://example.com/static/feed.xml", links[0].URL)
+}
+
+func TestCreateSubscriptionDiscoversFeedsOfRedirectedPages(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	pageRequests := 0
+	p := &RSSFeedPlugin{FeedHandler: FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		body := `<rss version="2.0"><channel><title>Blog</title></channel></rss>`
+		resp := &http.Response{StatusCode: http.StatusOK, Request: req}
+		if req.URL.Path == "/" {
+			pageRequests++
+			// redirected to the blog, relative links are relative to it
+			final := *req.URL
+			final.Path = "/blog/"
+			resp.Request = &http.Request{URL: &final, Response: &http.Response{StatusCode: http.StatusFound, Request: req}}
+			body = `<html><head><link rel="alternate" type="application/rss+xml" href="feed.xml"></head></html>`
+		}
+		resp.Body = ioutil.NopCloser(strings.NewReader(body))
+		return resp, nil
+	})}}
+	p.SetAPI(api)
+
+	sub, _, err := p.createSubscription(context.Background(), "https://example.com/", "channel", "user")
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/blog/feed.xml", sub.URL)
+	assert.Equal(t, "Blog", sub.Title)
+	// the page isn't fetched again to find its feeds
+	assert.Equal(t, 1, pageRequests)
+}
+
+func TestHandleHTTPDiscoverRejectsForeignRequests(t *testing.T) {
+	userID := model.NewId()
+	channelID := model.NewId()
+	api := &plugintest.API{}
+	api.On("HasPermissionToChannel", userID, channelID, model.PERMISSION_READ_CHANNEL).Return(true)
+	api.On("HasPermissionToChannel", mock.Anything, mock.Anything, mock.Anything).Return(false)
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	discover := func(sessionUserID string, request *model.PostActionIntegrationRequest) int {
+		body, err := json.Marshal(request)
+		require.NoError(t, err)
+		r := httptest.NewRequest(http.MethodPost, "/discover", bytes.NewReader(body))
+		if sessionUserID != "" {
+			r.Header.Set("Mattermost-User-Id", sessionUserID)
+		}
+		w := httptest.NewRecorder()
+		p.handleHTTPDiscover(w, r)
+		return w.Code
+	}
+	request := func(channelID string, selected string) *model.PostActionIntegrationRequest {
+		return &model.PostActionIntegrationRequest{
+			UserId:    userID,
+			ChannelId: channelID,
+			Context: model.StringInterface{
+				"action":          "post",
+				"page":            "https://example.com/",
+				"urls":            []interface{}{"https://example.com/feed.xml"},
+				"selected_option": selected,
+			},
+		}
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, discover("", request(channelID, "https://example.com/feed.xml")))
+	assert.Equal(t, http.StatusForbidden, discover(model.NewId(), request(channelID, "https://example.com/feed.xml")))
+	assert.Equal(t, http.StatusForbidden, discover(userID, request(model.NewId(), "https://example.com/feed.xml")))
+	// only the discovered feeds can be picked
+	assert.Equal(t, http.StatusBadRequest, discover(userID, request(channelID, "http://10.0.0.1/internal")))
+}
@@ -1,17 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/lunny/html2md"
 	"github.com/mattermost/mattermost-server/model"
-	"golang.org/x/net/html/charset"
+	"golang.org/x/tools/blog/atom"
 )
 
 type FeedFormat int8
@@ -19,8 +23,24 @@ type FeedFormat int8
 const (
 	FeedFormatRSSV2 FeedFormat = 1
 	FeedFormatAtom  FeedFormat = 2
+	FeedFormatJSON  FeedFormat = 3
+	FeedFormatRSSV1 FeedFormat = 4
 )
 
+func (f FeedFormat) String() string {
+	switch f {
+	case FeedFormatRSSV2:
+		return "rss"
+	case FeedFormatAtom:
+		return "atom"
+	case FeedFormatJSON:
+		return "json"
+	case FeedFormatRSSV1:
+		return "rdf"
+	}
+	return "unknown"
+}
+
 type FeedInfo struct {
 	Title      string
 	AuthorName string
@@ -29,15 +49,36 @@ type FeedInfo struct {
 	Alternate  string
 	Icon       string
 	Generator  string
+	Hub        string // WebSub hub the feed is published to
+	Self       string // canonical url of the feed
+	Cloud      string // rssCloud http-post registration endpoint
 }
 
 type FeedHandler interface {
-	processFeed(*Subscription, *configuration) ([]*model.SlackAttachment, error)
-	processRSSV2Feed(*Subscription, *RSSV2, string, *configuration) ([]*model.SlackAttachment, error)
-	processAtomFeed(*Subscription, *AtomFeed, *configuration) ([]*model.SlackAttachment, error)
+	processFeed(context.Context, *Subscription, *configuration) ([]*FeedItem, *CacheValidators, error)
+	processFeedBody(context.Context, *Subscription, string, *configuration) ([]*FeedItem, error)
+	processRSSV2Feed(*Subscription, *RSSV2, *configuration) ([]*FeedItem, error)
+	processAtomFeed(*Subscription, *AtomFeed, *configuration) ([]*FeedItem, error)
+	processJSONFeed(*Subscription, *JSONFeed, *configuration) ([]*FeedItem, error)
+	processRSSV1Feed(*Subscription, *RSSV1, *configuration) ([]*FeedItem, error)
+
+	FetchFeedInfo(ctx context.Context, url string) (*FeedInfo, error)
+	FetchFeedBody(ctx context.Context, subs *Subscription) (string, *CacheValidators, error)
+	DiscoverFeeds(ctx context.Context, url string) ([]*FeedLink, error)
+	FetchOPML(ctx context.Context, url string) (*OPML, error)
+	RequestWebSub(ctx context.Context, hub string, mode string, topic string, callback string, secret string) error
+	RequestRSSCloud(ctx context.Context, cloud string, feedURL string, callback *url.URL) error
+}
 
-	FetchFeedInfo(url string) (*FeedInfo, error)
-	FetchFeedBody(subs *Subscription) (string, error)
+// HTTPStatusError - the server responded with something other than 200 or 304
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s responded with %s", e.URL, e.Status)
 }
 
 type HTTPClient interface {
@@ -48,7 +89,12 @@ type FeedHandlerDefault struct {
 	client HTTPClient
 }
 
-const RelAlternate = "alternate"
+const (
+	RelAlternate = "alternate"
+	RelEnclosure = "enclosure"
+	RelHub       = "hub"
+	RelSelf      = "self"
+)
 
 func newFeedHandler() FeedHandler {
 	//src: https://blog.cloudflare.com/the-complete-guide-to-golang-net-http-timeouts/
@@ -67,98 +113,229 @@ func newFeedHandler() FeedHandler {
 	}
 }
 
-func (h FeedHandlerDefault) processFeed(subscription *Subscription, config *configuration) ([]*model.SlackAttachment, error) {
+// processFeed fetches the feed and finds its new and changed items, the cache validators
+// of the response are returned for the caller to store once the items were handled
+func (h FeedHandlerDefault) processFeed(ctx context.Context, subscription *Subscription, config *configuration) ([]*FeedItem, *CacheValidators, error) {
 	if len(subscription.URL) == 0 {
-		return nil, errors.New("no url supplied")
+		return nil, nil, errors.New("no url supplied")
 	}
 
-	body, err := h.FetchFeedBody(subscription)
+	body, validators, err := h.FetchFeedBody(ctx, subscription)
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	if body == "" {
-		return nil, nil
+		return nil, validators, nil
 	}
 
-	decoder := xml.NewDecoder(strings.NewReader(body))
-	decoder.CharsetReader = charset.NewReaderLabel
-
-	if subscription.Format == FeedFormatRSSV2 {
-		rssFeed, err := RSSV2ParseString(body)
+	parse := func() (interface{}, error) {
+		return parseFeedBody(subscription.Format, body)
+	}
 
-		if err != nil {
-			return nil, err
-		}
-		return h.processRSSV2Feed(subscription, rssFeed, body, config)
+	var feed interface{}
+	if cycle := fetchCycleFrom(ctx); cycle != nil {
+		feed, err = cycle.parse(subscription, body, parse)
+	} else {
+		feed, err = parse()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if validators != nil && validators.MovedTo != "" {
+		subscription.URL = validators.MovedTo
 	}
 
-	if subscription.Format == FeedFormatAtom {
-		atomFeed, err := AtomParseString(body)
+	fetchedURL := subscription.URL
+	items, err := h.processParsedFeed(ctx, subscription, feed, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if subscription.URL != fetchedURL {
+		// moved to its self link, the validators belong to the old url
+		validators = nil
+	}
+	return items, validators, nil
+}
 
-		if err != nil {
-			return nil, err
-		}
-		return h.processAtomFeed(subscription, atomFeed, config)
+// processFeedBody parses an already fetched or pushed feed document
+func (h FeedHandlerDefault) processFeedBody(ctx context.Context, subscription *Subscription, body string, config *configuration) ([]*FeedItem, error) {
+	feed, err := parseFeedBody(subscription.Format, body)
+	if err != nil {
+		return nil, err
 	}
+	return h.processParsedFeed(ctx, subscription, feed, config)
+}
 
+// parseFeedBody returns the parsed document, *RSSV2, *AtomFeed, *JSONFeed or *RSSV1 depending on format
+func parseFeedBody(format FeedFormat, body string) (interface{}, error) {
+	switch format {
+	case FeedFormatRSSV2:
+		return RSSV2ParseString(body)
+	case FeedFormatAtom:
+		return AtomParseString(body)
+	case FeedFormatJSON:
+		return JSONFeedParseString(body)
+	case FeedFormatRSSV1:
+		return RSSV1ParseString(body)
+	}
 	return nil, errors.New("invalid feed format")
 }
 
-func (h FeedHandlerDefault) processRSSV2Feed(subscription *Subscription, newRssFeed *RSSV2, newRssFeedString string, config *configuration) ([]*model.SlackAttachment, error) {
-	// retrieve old xml feed from database
-	oldRssFeed, err := RSSV2ParseString(subscription.XML)
-	if err != nil {
-		return nil, err
+// processParsedFeed finds the new and changed items of a document returned by parseFeedBody,
+// the document may be shared with other subscriptions and must not be modified
+func (h FeedHandlerDefault) processParsedFeed(ctx context.Context, subscription *Subscription, feed interface{}, config *configuration) ([]*FeedItem, error) {
+	switch feed := feed.(type) {
+	case *RSSV2:
+		h.applySelfLink(ctx, subscription, feed.Channel.AtomLinks)
+		applyScheduleHints(subscription, &feed.Channel)
+		return h.processRSSV2Feed(subscription, feed, config)
+	case *AtomFeed:
+		h.applySelfLink(ctx, subscription, feed.Link)
+		return h.processAtomFeed(subscription, feed, config)
+	case *JSONFeed:
+		return h.processJSONFeed(subscription, feed, config)
+	case *RSSV1:
+		return h.processRSSV1Feed(subscription, feed, config)
 	}
+	return nil, errors.New("invalid feed format")
+}
+
+func (h FeedHandlerDefault) processRSSV2Feed(subscription *Subscription, newRssFeed *RSSV2, config *configuration) ([]*FeedItem, error) {
+	fingerprints := newRssFeed.Fingerprints()
+	revisions := make([]string, len(fingerprints))
+
+	items := []*FeedItem{}
+	for index := range newRssFeed.Channel.ItemList {
+		item := &newRssFeed.Channel.ItemList[index]
+		revisions[index] = item.Revision()
+
+		if !subscription.Seen.changed(fingerprints[index], revisions[index]) {
+			continue
+		}
 
-	items := RSSV2CompareItemsBetweenOldAndNew(oldRssFeed, newRssFeed)
-	attachments := make([]*model.SlackAttachment, len(items))
-	for index, item := range items {
 		attachment := &model.SlackAttachment{
 			Title:     item.Title,
 			TitleLink: item.Link,
+			Timestamp: RSSV2ParseTimestamp(item.PubDate),
 		}
 
+		description := html2md.Convert(item.Description)
 		if config.ShowDescription {
-			attachment.Text = html2md.Convert(item.Description)
+			attachment.Text = description
 		}
-		attachments[index] = attachment
-	}
-	if len(items) > 0 {
-		subscription.XML = newRssFeedString
+
+		feedItem := subscription.Seen.feedItem(fingerprints[index], attachment)
+		feedItem.Fields = ItemFields{
+			Title:      item.Title,
+			Content:    description,
+			Author:     item.Author,
+			Link:       item.Link,
+			Categories: item.Categories(),
+		}
+		if item.Enclosure.URL != "" {
+			length, _ := strconv.ParseInt(strings.TrimSpace(item.Enclosure.Length), 10, 64)
+			feedItem.Fields.Enclosures = []ItemEnclosure{{URL: item.Enclosure.URL, Type: item.Enclosure.Type, Length: length}}
+		}
+		items = append(items, feedItem)
 	}
+	subscription.markSeen(fingerprints, revisions, time.Now().Unix())
 
 	subscription.Timestamp = time.Now().Unix()
 
-	return attachments, nil
+	return items, nil
 }
 
-func (h FeedHandlerDefault) processAtomFeed(subscription *Subscription, feed *AtomFeed, config *configuration) ([]*model.SlackAttachment, error) {
-	feedTimestamp := AtomParseTimestamp(feed.Updated)
+func (h FeedHandlerDefault) processRSSV1Feed(subscription *Subscription, newRDFFeed *RSSV1, config *configuration) ([]*FeedItem, error) {
+	fingerprints := newRDFFeed.Fingerprints()
+	revisions := make([]string, len(fingerprints))
 
-	if subscription.Timestamp >= AtomParseTimestamp(feed.Updated) {
-		return nil, nil
-	}
+	items := []*FeedItem{}
+	for index := range newRDFFeed.ItemList {
+		item := &newRDFFeed.ItemList[index]
+		revisions[index] = item.Revision()
 
-	items := feed.ItemsAfter(subscription.Timestamp)
-
-	attachments := make([]*model.SlackAttachment, len(items))
+		if !subscription.Seen.changed(fingerprints[index], revisions[index]) {
+			continue
+		}
 
-	for index, item := range items {
 		attachment := &model.SlackAttachment{
 			Title:      item.Title,
 			Fallback:   item.Title,
-			AuthorName: item.Author.Name,
-			AuthorLink: item.Author.URI,
-			AuthorIcon: getGravatarIcon(item.Author.Email, config.GravatarDefault),
+			TitleLink:  item.Link,
+			AuthorName: item.Creator,
 			Color:      subscription.Color,
+			Timestamp:  RSSV1ParseTimestamp(item.Date),
+		}
+
+		description := item.Description
+		if item.Content != "" {
+			description = item.Content
+		}
+		description = html2md.Convert(description)
+		if config.ShowDescription {
+			attachment.Text = description
+		}
+
+		feedItem := subscription.Seen.feedItem(fingerprints[index], attachment)
+		feedItem.Fields = ItemFields{
+			Title:      item.Title,
+			Content:    description,
+			Author:     item.Creator,
+			Link:       item.Link,
+			Categories: item.Categories(),
+		}
+		items = append(items, feedItem)
+	}
+	subscription.markSeen(fingerprints, revisions, time.Now().Unix())
+
+	subscription.Timestamp = time.Now().Unix()
+
+	return items, nil
+}
+
+func (h FeedHandlerDefault) processAtomFeed(subscription *Subscription, feed *AtomFeed, config *configuration) ([]*FeedItem, error) {
+	// older versions only remembered the time of the last update,
+	// entries from before it have already been posted
+	postedBefore := int64(0)
+	if subscription.Seen == nil {
+		postedBefore = subscription.Timestamp
+	}
+
+	fingerprints := make([]string, len(feed.Entry))
+	revisions := make([]string, len(feed.Entry))
+
+	items := []*FeedItem{}
+	for index, item := range feed.Entry {
+		fingerprints[index] = AtomEntryFingerprint(item)
+		revisions[index] = AtomEntryRevision(item)
+
+		if postedBefore > 0 && AtomParseTimestamp(item.Updated) <= postedBefore {
+			continue
+		}
+		if !subscription.Seen.changed(fingerprints[index], revisions[index]) {
+			continue
 		}
 
-		attachments[index] = attachment
+		attachment := &model.SlackAttachment{
+			Title:    item.Title,
+			Fallback: item.Title,
+			Color:    subscription.Color,
+		}
+
+		if item.Author != nil {
+			attachment.AuthorName = item.Author.Name
+			attachment.AuthorLink = item.Author.URI
+			attachment.AuthorIcon = getGravatarIcon(item.Author.Email, config.GravatarDefault)
+		}
+
+		enclosures := []ItemEnclosure{}
 		for _, link := range item.Link {
-			if link.Rel == RelAlternate {
+			switch link.Rel {
+			case RelAlternate:
 				attachment.TitleLink = link.Href
+			case RelEnclosure:
+				enclosures = append(enclosures, ItemEnclosure{URL: link.Href, Type: link.Type, Title: link.Title, Length: int64(link.Length)})
 			}
 		}
 
@@ -170,63 +347,281 @@ func (h FeedHandlerDefault) processAtomFeed(subscription *Subscription, feed *At
 		}
 
 		if item.Content != nil {
-			body := attachment.Text
+			body := item.Content.Body
 			if item.Content.Type != "text" {
 				body = html2md.Convert(body)
 			}
 			attachment.Text = strings.TrimSpace(body)
 		}
+
+		feedItem := subscription.Seen.feedItem(fingerprints[index], attachment)
+		feedItem.Fields = ItemFields{
+			Title:      item.Title,
+			Content:    attachment.Text,
+			Author:     attachment.AuthorName,
+			Link:       attachment.TitleLink,
+			Categories: item.Categories(),
+			Enclosures: enclosures,
+		}
+		if feedItem.Fields.Content == "" && item.Summary != nil {
+			feedItem.Fields.Content = item.Summary.Body
+			if item.Summary.Type != "text" {
+				feedItem.Fields.Content = html2md.Convert(item.Summary.Body)
+			}
+		}
+		items = append(items, feedItem)
 	}
+	subscription.markSeen(fingerprints, revisions, time.Now().Unix())
 
-	subscription.Timestamp = feedTimestamp
+	subscription.Timestamp = AtomParseTimestamp(feed.Updated)
 
-	return attachments, nil
+	return items, nil
 }
 
-func (h FeedHandlerDefault) FetchFeedBody(sub *Subscription) (string, error) {
+func (h FeedHandlerDefault) processJSONFeed(subscription *Subscription, feed *JSONFeed, config *configuration) ([]*FeedItem, error) {
+	fingerprints := make([]string, len(feed.Items))
+	revisions := make([]string, len(feed.Items))
+
+	items := []*FeedItem{}
+	for index, item := range feed.Items {
+		fingerprints[index] = item.Fingerprint()
+		revisions[index] = item.Revision()
+
+		if !subscription.Seen.changed(fingerprints[index], revisions[index]) {
+			continue
+		}
+
+		attachment := &model.SlackAttachment{
+			Title:     item.Title,
+			Fallback:  item.Title,
+			TitleLink: item.URL,
+			ImageURL:  item.Image,
+			Color:     subscription.Color,
+			Timestamp: item.Timestamp(),
+		}
+
+		if attachment.TitleLink == "" {
+			attachment.TitleLink = item.ExternalURL
+		}
+
+		authors := item.Authors
+		if len(authors) == 0 {
+			authors = feed.Authors
+		}
+		if len(authors) > 0 {
+			attachment.AuthorName = authors[0].Name
+			attachment.AuthorLink = authors[0].URL
+			attachment.AuthorIcon = authors[0].Avatar
+		}
+		if attachment.AuthorIcon == "" {
+			attachment.AuthorIcon = getGravatarIcon("", config.GravatarDefault)
+		}
+
+		if item.ContentHTML != "" {
+			attachment.Text = strings.TrimSpace(html2md.Convert(item.ContentHTML))
+		} else {
+			attachment.Text = strings.TrimSpace(item.ContentText)
+		}
+
+		for _, enclosure := range item.Attachments {
+			title := enclosure.Title
+			if title == "" {
+				title = enclosure.URL
+			}
+			attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+				Title: "Attachment",
+				Value: fmt.Sprintf("[%s](%s) %s", title, enclosure.URL, enclosure.MimeType),
+			})
+		}
+
+		feedItem := subscription.Seen.feedItem(fingerprints[index], attachment)
+		feedItem.Fields = ItemFields{
+			Title:   item.Title,
+			Content: attachment.Text,
+			Author:  attachment.AuthorName,
+			Link:    attachment.TitleLink,
+		}
+		for _, tag := range item.Tags {
+			feedItem.Fields.Categories = append(feedItem.Fields.Categories, ItemCategory{Name: tag})
+		}
+		for _, enclosure := range item.Attachments {
+			feedItem.Fields.Enclosures = append(feedItem.Fields.Enclosures, ItemEnclosure{
+				URL:    enclosure.URL,
+				Type:   enclosure.MimeType,
+				Title:  enclosure.Title,
+				Length: enclosure.SizeInBytes,
+			})
+		}
+		if feedItem.Fields.Content == "" {
+			feedItem.Fields.Content = item.Summary
+		}
+		items = append(items, feedItem)
+	}
+	subscription.markSeen(fingerprints, revisions, time.Now().Unix())
+
+	subscription.Timestamp = time.Now().Unix()
+
+	return items, nil
+}
+
+func (h FeedHandlerDefault) FetchFeedBody(ctx context.Context, sub *Subscription) (string, *CacheValidators, error) {
+	now := time.Now()
+
+	// the server said the last response is still fresh, no need to ask again
+	if sub.CacheUntil > now.Unix() {
+		sub.CacheStats.Fresh++
+		return "", nil, errCacheFresh
+	}
+
 	req, err := http.NewRequest("GET", sub.URL, nil)
 
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
+	req = req.WithContext(ctx)
 
 	// setting ETag will (depending on the support of the server)
 	// return only entries from after the date or NotModified if there are none
 	// https://en.wikipedia.org/wiki/HTTP_ETag
-	req.Header.Add("If-None-Match", sub.ETag)
+	if sub.ETag != "" {
+		req.Header.Add("If-None-Match", sub.ETag)
+	}
+	if sub.LastModified != "" {
+		req.Header.Add("If-Modified-Since", sub.LastModified)
+	}
+
+	var body string
+	var resp *http.Response
+	shared := false
+	if cycle := fetchCycleFrom(ctx); cycle != nil {
+		body, resp, shared, err = cycle.fetch(ctx, sub, func() (string, *http.Response, error) {
+			return h.fetchResponse(req)
+		})
+	} else {
+		body, resp, err = h.fetchResponse(req)
+	}
+
+	if resp != nil {
+		switch {
+		case shared:
+			sub.CacheStats.Shared++
+		case resp.StatusCode == http.StatusNotModified:
+			sub.CacheStats.Requests++
+			sub.CacheStats.NotModified++
+		default:
+			sub.CacheStats.Requests++
+		}
+	}
+	if err != nil {
+		return "", nil, err
+	}
+
+	validators := responseValidators(resp, now)
+	if target, ok := permanentRedirectTarget(resp); ok && validators != nil {
+		// followed by processFeed once the body turned out to be a feed
+		validators.MovedTo = target
+	}
+	return body, validators, nil
+}
+
+// permanentRedirectTarget returns the final url if the response was reached
+// only through permanent redirects (301, 308)
+func permanentRedirectTarget(resp *http.Response) (string, bool) {
+	if resp == nil || resp.Request == nil || resp.Request.Response == nil {
+		return "", false
+	}
+
+	// every redirected request links to the response that caused it
+	for req := resp.Request; req.Response != nil; req = req.Response.Request {
+		code := req.Response.StatusCode
+		if code != http.StatusMovedPermanently && code != http.StatusPermanentRedirect {
+			return "", false
+		}
+		if req.Response.Request == nil {
+			break
+		}
+	}
+
+	return resp.Request.URL.String(), true
+}
 
-	return h.fetchRequest(req)
+// selfLink returns the absolute http(s) rel="self" link, empty if there is none
+func selfLink(links []atom.Link) string {
+	for _, link := range links {
+		if link.Rel != RelSelf {
+			continue
+		}
+
+		self, err := url.Parse(link.Href)
+		if err != nil || !self.IsAbs() || (self.Scheme != "http" && self.Scheme != "https") {
+			return ""
+		}
+		return self.String()
+	}
+	return ""
+}
+
+// applySelfLink moves the subscription to the rel="self" link, the canonical url of the feed,
+// once fetching it succeeded. Stale or mirrored self links are common, every link is only tried once
+func (h FeedHandlerDefault) applySelfLink(ctx context.Context, sub *Subscription, links []atom.Link) {
+	self := selfLink(links)
+	if self == "" || self == sub.SelfLink || normalizeFeedURL(self) == normalizeFeedURL(sub.URL) {
+		return
+	}
+	sub.SelfLink = self
+
+	if _, err := h.FetchFeedInfo(ctx, self); err != nil {
+		return
+	}
+	sub.URL = self
+	// the validators belong to the old url
+	sub.ETag = ""
+	sub.LastModified = ""
 }
 
 func (h FeedHandlerDefault) fetchRequest(req *http.Request) (string, error) {
+	body, _, err := h.fetchResponse(req)
+	return body, err
+}
+
+// fetchResponse returns the body along with the response for access to the headers,
+// the body of the response has already been read and closed
+func (h FeedHandlerDefault) fetchResponse(req *http.Request) (string, *http.Response, error) {
+	release, err := acquireFetchSlot(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return "", nil, err
+	}
+	defer release()
+
 	resp, err := h.client.Do(req)
 	if err != nil {
-		return "", err
+		return "", nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode == http.StatusNotModified {
-		return "", nil
+		return "", resp, nil
 	} else if resp.StatusCode != http.StatusOK {
-		return "", err
+		return "", resp, &HTTPStatusError{URL: req.URL.String(), StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	body, err := ioutil.ReadAll(resp.Body)
 
 	if err != nil {
-		return "", err
+		return "", resp, err
 	}
 
-	return string(body), nil
+	return string(body), resp, nil
 }
 
-func (h FeedHandlerDefault) FetchFeedInfo(url string) (*FeedInfo, error) {
+func (h FeedHandlerDefault) FetchFeedInfo(ctx context.Context, url string) (*FeedInfo, error) {
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	req = req.WithContext(ctx)
 
-	body, err := h.fetchRequest(req)
+	body, resp, err := h.fetchResponse(req)
 	if err != nil {
 		return nil, err
 	}
@@ -244,9 +639,17 @@ func (h FeedHandlerDefault) FetchFeedInfo(url string) (*FeedInfo, error) {
 		}
 
 		for _, link := range atomFeed.Link {
-			if link.Rel == RelAlternate {
-				info.Alternate = link.Href
-				break
+			switch link.Rel {
+			case RelAlternate:
+				if info.Alternate == "" {
+					info.Alternate = link.Href
+				}
+			case RelHub:
+				if info.Hub == "" {
+					info.Hub = link.Href
+				}
+			case RelSelf:
+				info.Self = link.Href
 			}
 		}
 
@@ -257,12 +660,214 @@ func (h FeedHandlerDefault) FetchFeedInfo(url string) (*FeedInfo, error) {
 
 	if err == nil {
 		info := &FeedInfo{
-			Title:  rssFeed.Channel.Title,
-			Format: FeedFormatRSSV2,
+			Title:     rssFeed.Channel.Title,
+			Format:    FeedFormatRSSV2,
+			Alternate: rssFeed.Channel.Link,
+			Generator: rssFeed.Channel.Generator,
+		}
+
+		info.Cloud = rssFeed.Channel.Cloud.RegisterURL()
+
+		for _, link := range rssFeed.Channel.AtomLinks {
+			switch link.Rel {
+			case RelHub:
+				if info.Hub == "" {
+					info.Hub = link.Href
+				}
+			case RelSelf:
+				info.Self = link.Href
+			}
 		}
 
 		return info, nil
 	}
 
-	return nil, errors.New("invalid feed")
+	rdfFeed, err := RSSV1ParseString(body)
+
+	if err == nil {
+		info := &FeedInfo{
+			Title:      rdfFeed.Channel.Title,
+			Format:     FeedFormatRSSV1,
+			AuthorName: rdfFeed.Channel.Creator,
+			Alternate:  rdfFeed.Channel.Link,
+		}
+
+		if info.AuthorName == "" {
+			info.AuthorName = rdfFeed.Channel.Publisher
+		}
+
+		return info, nil
+	}
+
+	jsonFeed, err := JSONFeedParseString(body)
+
+	if err == nil {
+		info := &FeedInfo{
+			Title:     jsonFeed.Title,
+			Format:    FeedFormatJSON,
+			Alternate: jsonFeed.HomePageURL,
+			Icon:      jsonFeed.Icon,
+			Self:      jsonFeed.FeedURL,
+		}
+
+		for _, hub := range jsonFeed.Hubs {
+			if strings.EqualFold(hub.Type, "websub") {
+				info.Hub = hub.URL
+				break
+			}
+		}
+
+		if info.Icon == "" {
+			info.Icon = jsonFeed.Favicon
+		}
+
+		if len(jsonFeed.Authors) > 0 {
+			info.AuthorName = jsonFeed.Authors[0].Name
+			info.AuthorURL = jsonFeed.Authors[0].URL
+		}
+
+		return info, nil
+	}
+
+	// the page may advertise its feeds, see createSubscription
+	return nil, &notAFeedError{links: FindFeedLinks(body, responseURL(req, resp))}
+}
+
+// notAFeedError is returned by FetchFeedInfo when the document isn't a feed,
+// along with the feeds it advertises if it is a website
+type notAFeedError struct {
+	links []*FeedLink
+}
+
+func (e *notAFeedError) Error() string {
+	return "invalid feed"
+}
+
+// responseURL - the url the response was read from, after following redirects
+func responseURL(req *http.Request, resp *http.Response) *url.URL {
+	if resp != nil && resp.Request != nil && resp.Request.URL != nil {
+		return resp.Request.URL
+	}
+	return req.URL
+}
+
+func (h FeedHandlerDefault) DiscoverFeeds(ctx context.Context, pageURL string) ([]*FeedLink, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	body, resp, err := h.fetchResponse(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return FindFeedLinks(body, responseURL(req, resp)), nil
+}
+
+func (h FeedHandlerDefault) FetchOPML(ctx context.Context, url string) (*OPML, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	body, err := h.fetchRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	return OPMLParseString(body)
+}
+
+// RequestWebSub asks the hub to (un)subscribe the callback to topic,
+// the hub confirms asynchronously by calling the callback with a challenge
+func (h FeedHandlerDefault) RequestWebSub(ctx context.Context, hub string, mode string, topic string, callback string, secret string) error {
+	form := url.Values{
+		"hub.callback": {callback},
+		"hub.mode":     {mode},
+		"hub.topic":    {topic},
+	}
+	if mode == WebSubModeSubscribe {
+		form.Set("hub.secret", secret)
+		form.Set("hub.lease_seconds", strconv.Itoa(WebSubLeaseSeconds))
+	}
+
+	req, err := http.NewRequest("POST", hub, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	release, err := acquireFetchSlot(ctx, req.URL.Hostname())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("hub %s responded with %s", hub, resp.Status)
+	}
+	return nil
+}
+
+// RequestRSSCloud registers callback to be notified when the feed changes,
+// registrations expire after 25 hours
+func (h FeedHandlerDefault) RequestRSSCloud(ctx context.Context, cloud string, feedURL string, callback *url.URL) error {
+	port := callback.Port()
+	if port == "" {
+		port = "80"
+		if callback.Scheme == "https" {
+			port = "443"
+		}
+	}
+
+	form := url.Values{
+		"notifyProcedure": {""},
+		"port":            {port},
+		"path":            {callback.Path},
+		"protocol":        {RSSCloudProtocolHTTPPost},
+		"domain":          {callback.Hostname()},
+		"url1":            {feedURL},
+	}
+
+	req, err := http.NewRequest("POST", cloud, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	release, err := acquireFetchSlot(ctx, req.URL.Hostname())
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cloud %s responded with %s", cloud, resp.Status)
+	}
+
+	result := RSSCloudNotifyResult{}
+	if err := xml.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("cloud %s refused registration: %s", cloud, result.Message)
+	}
+	return nil
 }
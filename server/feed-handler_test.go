@@ -1,9 +1,17 @@
 package main
 
 import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
 )
 
 type HTTPClientMock struct {
@@ -41,3 +49,123 @@ func TestFetchInfo(t *testing.T) {
 		mockClient.On("Do", mock.Anything).Return(response)
 	}
 }
+
+func redirectedResponse(codes ...int) *http.Response {
+	req := &http.Request{URL: &url.URL{Scheme: "https", Host: "example.com", Path: "/0"}}
+	for i, code := range codes {
+		req = &http.Request{
+			URL:      &url.URL{Scheme: "https", Host: "example.com", Path: "/" + string(rune('1'+i))},
+			Response: &http.Response{StatusCode: code, Request: req},
+		}
+	}
+	return &http.Response{StatusCode: http.StatusOK, Request: req}
+}
+
+func TestPermanentRedirectTarget(t *testing.T) {
+	target, ok := permanentRedirectTarget(redirectedResponse(http.StatusMovedPermanently, http.StatusPermanentRedirect))
+	assert.True(t, ok)
+	assert.Equal(t, "https://example.com/2", target)
+
+	_, ok = permanentRedirectTarget(redirectedResponse(http.StatusMovedPermanently, http.StatusFound))
+	assert.False(t, ok)
+
+	_, ok = permanentRedirectTarget(redirectedResponse())
+	assert.False(t, ok)
+}
+
+// clientFunc - an HTTPClient answering with a function
+type clientFunc func(req *http.Request) (*http.Response, error)
+
+func (f clientFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestApplySelfLink(t *testing.T) {
+	feed := responses[0].Body
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != "example.com" {
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(feed))}, nil
+	})}
+
+	sub := &Subscription{URL: "https://mirror.example.org/feed", ETag: `"1"`}
+	handler.applySelfLink(context.Background(), sub, []atom.Link{{Rel: RelSelf, Href: "https://example.com/feed"}})
+	assert.Equal(t, "https://example.com/feed", sub.URL)
+	assert.Empty(t, sub.ETag)
+
+	// a self link that can't be fetched is tried once and ignored
+	sub = &Subscription{URL: "https://example.com/feed"}
+	handler.applySelfLink(context.Background(), sub, []atom.Link{{Rel: RelSelf, Href: "https://stale.example.org/feed"}})
+	assert.Equal(t, "https://example.com/feed", sub.URL)
+	assert.Equal(t, "https://stale.example.org/feed", sub.SelfLink)
+
+	// the same url written differently isn't a move
+	sub = &Subscription{URL: "https://EXAMPLE.com:443/feed"}
+	handler.applySelfLink(context.Background(), sub, []atom.Link{{Rel: RelSelf, Href: "https://example.com/feed"}})
+	assert.Equal(t, "https://EXAMPLE.com:443/feed", sub.URL)
+	assert.Empty(t, sub.SelfLink)
+}
+
+func TestProcessFeedKeepsValidatorsOfBrokenFeeds(t *testing.T) {
+	body := "not a feed"
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		header := http.Header{}
+		header.Set("ETag", `"new"`)
+		header.Set("Cache-Control", "max-age=3600")
+		return &http.Response{StatusCode: http.StatusOK, Header: header, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}
+
+	// the response can't be parsed, the next poll must fetch it again
+	sub := &Subscription{URL: "https://example.com/feed", Format: FeedFormatAtom, ETag: `"old"`}
+	_, validators, err := handler.processFeed(context.Background(), sub, &configuration{})
+	assert.Error(t, err)
+	assert.Nil(t, validators)
+	assert.Equal(t, `"old"`, sub.ETag)
+	assert.Zero(t, sub.CacheUntil)
+
+	body = responses[0].Body
+	_, validators, err = handler.processFeed(context.Background(), sub, &configuration{})
+	require.NoError(t, err)
+	require.NotNil(t, validators)
+	assert.Equal(t, `"new"`, validators.ETag)
+	assert.Equal(t, `"old"`, sub.ETag)
+}
+
+func TestProcessFeedFollowsPermanentRedirectsToFeeds(t *testing.T) {
+	body := "<html>Parked domain</html>"
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		resp := redirectedResponse(http.StatusMovedPermanently)
+		resp.Body = ioutil.NopCloser(strings.NewReader(body))
+		return resp, nil
+	})}
+
+	// a redirect to something that isn't a feed doesn't move the subscription
+	sub := &Subscription{URL: "https://example.com/0", Format: FeedFormatAtom}
+	_, _, err := handler.processFeed(context.Background(), sub, &configuration{})
+	assert.Error(t, err)
+	assert.Equal(t, "https://example.com/0", sub.URL)
+
+	body = `<feed xmlns="http://www.w3.org/2005/Atom"><title>Moved</title>
+		<entry><id>1</id><title>First</title></entry>
+	</feed>`
+	_, validators, err := handler.processFeed(context.Background(), sub, &configuration{})
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/1", sub.URL)
+	require.NotNil(t, validators)
+	assert.Equal(t, "https://example.com/1", validators.MovedTo)
+}
+
+func TestProcessAtomFeedWithoutDates(t *testing.T) {
+	feed, err := AtomParseString(`<feed xmlns="http://www.w3.org/2005/Atom">
+	<title>Example</title>
+	<entry><id>1</id><title>Undated</title><link href="https://example.com/1"/></entry>
+</feed>`)
+	require.NoError(t, err)
+
+	// entries without a date are new to a subscription without seen items
+	sub := &Subscription{URL: "https://example.com/feed"}
+	items, err := FeedHandlerDefault{}.processAtomFeed(sub, feed, &configuration{})
+	require.NoError(t, err)
+	assert.Len(t, items, 1)
+}
@@ -0,0 +1,499 @@
+/*
+Filter rules of subscriptions
+
+An include rule lets only the items matching its expression through, an exclude rule drops them.
+Items are posted if they match one of the include rules, or there are none, and none of the exclude rules.
+Filtered items are still remembered as seen, so they don't come back once the rules change.
+
+Expressions combine terms with AND, OR, NOT and parentheses, terms next to each other are ANDed:
+
+	kubernetes OR title:/CVE-\d+/
+	category:release NOT title:"-rc"
+
+A term is a keyword or a "quoted phrase", matched case insensitively, or a /regular expression/.
+Terms match any field unless scoped to one with title:, content:, author:, category: or link:.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// filter modes
+const (
+	FilterInclude = "include"
+	FilterExclude = "exclude"
+)
+
+// fields of an item terms can be scoped to
+const (
+	FilterFieldTitle    = "title"
+	FilterFieldContent  = "content"
+	FilterFieldAuthor   = "author"
+	FilterFieldCategory = "category"
+	FilterFieldLink     = "link"
+)
+
+// filterFieldAliases - other names of the fields
+var filterFieldAliases = map[string]string{
+	"description": FilterFieldContent,
+	"summary":     FilterFieldContent,
+	"tag":         FilterFieldCategory,
+	"url":         FilterFieldLink,
+}
+
+// FilterRule - a filter of a subscription, see filter.go
+type FilterRule struct {
+	Mode       string // FilterInclude or FilterExclude
+	Expression string
+}
+
+func (r *FilterRule) String() string {
+	return fmt.Sprintf("%s `%s`", r.Mode, r.Expression)
+}
+
+// ItemFields - the parts of an item filter rules match against and templates see
+type ItemFields struct {
+	Title      string
+	Content    string // description, summary or content, as markdown
+	Author     string
+	Link       string
+	Categories []ItemCategory
+	Enclosures []ItemEnclosure
+}
+
+// values returns the values of field, all values for an unscoped term
+func (f *ItemFields) values(field string) []string {
+	switch field {
+	case FilterFieldTitle:
+		return []string{f.Title}
+	case FilterFieldContent:
+		return []string{f.Content}
+	case FilterFieldAuthor:
+		return []string{f.Author}
+	case FilterFieldLink:
+		return []string{f.Link}
+	case FilterFieldCategory:
+		return categoryNames(f.Categories)
+	}
+	return append([]string{f.Title, f.Content, f.Author, f.Link}, categoryNames(f.Categories)...)
+}
+
+// filterExpression - a parsed expression
+type filterExpression interface {
+	match(fields *ItemFields) bool
+}
+
+type filterAnd []filterExpression
+
+func (e filterAnd) match(fields *ItemFields) bool {
+	for _, operand := range e {
+		if !operand.match(fields) {
+			return false
+		}
+	}
+	return true
+}
+
+type filterOr []filterExpression
+
+func (e filterOr) match(fields *ItemFields) bool {
+	for _, operand := range e {
+		if operand.match(fields) {
+			return true
+		}
+	}
+	return false
+}
+
+type filterNot struct {
+	operand filterExpression
+}
+
+func (e filterNot) match(fields *ItemFields) bool {
+	return !e.operand.match(fields)
+}
+
+// filterTerm - a keyword, phrase or regular expression, scoped to field unless empty
+type filterTerm struct {
+	field   string
+	keyword string // lower case
+	regexp  *regexp.Regexp
+}
+
+func (e filterTerm) match(fields *ItemFields) bool {
+	for _, value := range fields.values(e.field) {
+		if e.regexp != nil {
+			if e.regexp.MatchString(value) {
+				return true
+			}
+		} else if strings.Contains(strings.ToLower(value), e.keyword) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFilterExpression parses the expression of a filter rule, see filter.go for the syntax
+func parseFilterExpression(expression string) (filterExpression, error) {
+	tokens, err := tokenizeFilter(expression)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("the filter is empty")
+	}
+
+	parser := &filterParser{tokens: tokens}
+	result, err := parser.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if parser.position < len(parser.tokens) {
+		return nil, fmt.Errorf("unexpected `%s`", parser.tokens[parser.position].text)
+	}
+	return result, nil
+}
+
+type filterToken struct {
+	text     string // as written
+	operator string // AND, OR, NOT, ( or ), empty for terms
+	term     filterTerm
+}
+
+// tokenizeFilter splits an expression into operators and terms
+func tokenizeFilter(expression string) ([]filterToken, error) {
+	tokens := []filterToken{}
+	rest := expression
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\n")
+		if rest == "" {
+			return tokens, nil
+		}
+
+		if rest[0] == '(' || rest[0] == ')' {
+			tokens = append(tokens, filterToken{text: rest[:1], operator: rest[:1]})
+			rest = rest[1:]
+			continue
+		}
+
+		start := rest
+		term := filterTerm{}
+		if colon := strings.IndexByte(rest, ':'); colon > 0 {
+			name := strings.ToLower(rest[:colon])
+			if alias, ok := filterFieldAliases[name]; ok {
+				name = alias
+			}
+			switch name {
+			case FilterFieldTitle, FilterFieldContent, FilterFieldAuthor, FilterFieldCategory, FilterFieldLink:
+				term.field = name
+				rest = rest[colon+1:]
+			}
+		}
+
+		var value string
+		var err error
+		switch {
+		case strings.HasPrefix(rest, `"`):
+			value, rest, err = readDelimited(rest, '"')
+			if err != nil {
+				return nil, err
+			}
+			term.keyword = strings.ToLower(value)
+
+		case strings.HasPrefix(rest, "/"):
+			value, rest, err = readDelimited(rest, '/')
+			if err != nil {
+				return nil, err
+			}
+			term.regexp, err = regexp.Compile(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression `%s`: %s", value, err.Error())
+			}
+
+		default:
+			end := strings.IndexAny(rest, " \t\r\n()")
+			if end == -1 {
+				end = len(rest)
+			}
+			value, rest = rest[:end], rest[end:]
+			term.keyword = strings.ToLower(value)
+		}
+
+		text := start[:len(start)-len(rest)]
+		if term.field == "" && term.regexp == nil && !strings.HasPrefix(text, `"`) {
+			switch operator := strings.ToUpper(value); operator {
+			case "AND", "OR", "NOT":
+				tokens = append(tokens, filterToken{text: text, operator: operator})
+				continue
+			}
+		}
+		if term.regexp == nil && term.keyword == "" {
+			return nil, fmt.Errorf("`%s` is empty", text)
+		}
+		tokens = append(tokens, filterToken{text: text, term: term})
+	}
+}
+
+// readDelimited reads a value enclosed in delim, a backslash escapes delim,
+// returns the value and what follows the closing delim
+func readDelimited(s string, delim byte) (string, string, error) {
+	value := strings.Builder{}
+	for i := 1; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == delim:
+			value.WriteByte(delim)
+			i++
+		case s[i] == delim:
+			return value.String(), s[i+1:], nil
+		default:
+			value.WriteByte(s[i])
+		}
+	}
+	return "", "", fmt.Errorf("missing closing %c in `%s`", delim, s)
+}
+
+type filterParser struct {
+	tokens   []filterToken
+	position int
+}
+
+func (p *filterParser) peek() string {
+	if p.position >= len(p.tokens) {
+		return ""
+	}
+	return p.tokens[p.position].operator
+}
+
+// parseOr - and (OR and)*
+func (p *filterParser) parseOr() (filterExpression, error) {
+	operands := filterOr{}
+	for {
+		operand, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+
+		if p.peek() != "OR" {
+			break
+		}
+		p.position++
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return operands, nil
+}
+
+// parseAnd - unary (AND? unary)*
+func (p *filterParser) parseAnd() (filterExpression, error) {
+	operands := filterAnd{}
+	for {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		operands = append(operands, operand)
+
+		if p.peek() == "AND" {
+			p.position++
+			continue
+		}
+		if p.position >= len(p.tokens) || p.peek() == "OR" || p.peek() == ")" {
+			break
+		}
+	}
+
+	if len(operands) == 1 {
+		return operands[0], nil
+	}
+	return operands, nil
+}
+
+// parseUnary - NOT unary | ( or ) | term
+func (p *filterParser) parseUnary() (filterExpression, error) {
+	if p.position >= len(p.tokens) {
+		return nil, errors.New("the filter ends unexpectedly")
+	}
+
+	token := p.tokens[p.position]
+	p.position++
+
+	switch token.operator {
+	case "NOT":
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return filterNot{operand: operand}, nil
+
+	case "(":
+		operand, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, errors.New("missing `)`")
+		}
+		p.position++
+		return operand, nil
+
+	case "":
+		return token.term, nil
+	}
+	return nil, fmt.Errorf("unexpected `%s`", token.text)
+}
+
+// validateFilterRule returns an error describing what is wrong with the rule
+func validateFilterRule(rule *FilterRule) error {
+	if rule.Mode != FilterInclude && rule.Mode != FilterExclude {
+		return fmt.Errorf("the mode must be `%s` or `%s`", FilterInclude, FilterExclude)
+	}
+	_, err := parseFilterExpression(rule.Expression)
+	return err
+}
+
+// compiledFilter - a filter rule with its parsed expression
+type compiledFilter struct {
+	rule       *FilterRule
+	expression filterExpression
+}
+
+// itemFilter - the parsed filters of a subscription, parsed once per poll rather than per item
+type itemFilter []compiledFilter
+
+// compileFilters parses the filters of the subscription
+func (s *Subscription) compileFilters() itemFilter {
+	filter := make(itemFilter, 0, len(s.Filters))
+	for _, rule := range s.Filters {
+		expression, err := parseFilterExpression(rule.Expression)
+		if err != nil {
+			// validated when added
+			continue
+		}
+		filter = append(filter, compiledFilter{rule: rule, expression: expression})
+	}
+	return filter
+}
+
+// rejection returns the rule dropping the item, nil if it passes the filters
+func (f itemFilter) rejection(fields *ItemFields) *FilterRule {
+	var unmatchedInclude *FilterRule
+	included := false
+
+	for _, compiled := range f {
+		rule := compiled.rule
+		matched := compiled.expression.match(fields)
+		switch rule.Mode {
+		case FilterExclude:
+			if matched {
+				return rule
+			}
+		case FilterInclude:
+			if matched {
+				included = true
+			} else if unmatchedInclude == nil {
+				unmatchedInclude = rule
+			}
+		}
+	}
+
+	if !included && unmatchedInclude != nil {
+		return unmatchedInclude
+	}
+	return nil
+}
+
+// filterItems returns the items passing the subscription's filters
+func (s *Subscription) filterItems(items []*FeedItem) []*FeedItem {
+	if len(s.Filters) == 0 {
+		return items
+	}
+
+	filter := s.compileFilters()
+	result := []*FeedItem{}
+	for _, item := range items {
+		if filter.rejection(&item.Fields) == nil {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// maxPreviewItems - number of items listed by /feed preview
+const maxPreviewItems = 25
+
+// previewFilters fetches the feed of the subscription and describes which items its filters,
+// and extra unless nil, would post or drop. Nothing is posted or stored
+func (p *RSSFeedPlugin) previewFilters(ctx context.Context, channelID string, id uint32, extra *FilterRule) (string, error) {
+	subs, err := p.getSubscriptions(channelID)
+	if err != nil {
+		return "", err
+	}
+	stored, _ := subs.findID(id)
+	if stored == nil {
+		return "", errors.New("id not found")
+	}
+
+	// a copy that has never seen the feed, so every item is new
+	sub := *stored
+	sub.ETag = ""
+	sub.LastModified = ""
+	sub.CacheUntil = 0
+	sub.Timestamp = 0
+	sub.Seen = SeenItems{}
+	sub.Filters = append([]*FilterRule{}, stored.Filters...)
+	if extra != nil {
+		sub.Filters = append(sub.Filters, extra)
+	}
+
+	items, _, err := p.processFeed(withFetchPool(ctx, p.getFetchPool()), &sub, p.getConfiguration())
+	if err != nil {
+		return "", err
+	}
+
+	if len(sub.Filters) == 0 {
+		return fmt.Sprintf("%s has no filters, all %d items would be posted", sub.markdownLink(), len(items)), nil
+	}
+
+	text := fmt.Sprintf("Filters of %s:\n", sub.markdownLink())
+	for i, rule := range sub.Filters {
+		text += fmt.Sprintf("%d. %s\n", i+1, rule.String())
+	}
+
+	filter := sub.compileFilters()
+	posted := 0
+	lines := []string{}
+	for _, item := range items {
+		rule := filter.rejection(&item.Fields)
+		if rule == nil {
+			posted++
+		}
+		if len(lines) >= maxPreviewItems {
+			continue
+		}
+
+		title := item.Fields.Title
+		if title == "" {
+			title = item.Fields.Link
+		}
+		if rule == nil {
+			lines = append(lines, fmt.Sprintf("* :white_check_mark: %s", title))
+		} else {
+			lines = append(lines, fmt.Sprintf("* :x: %s, dropped by %s", title, rule.String()))
+		}
+	}
+
+	text += fmt.Sprintf("\n%d of %d items would be posted:\n", posted, len(items)) + strings.Join(lines, "\n")
+	if len(items) > maxPreviewItems {
+		text += fmt.Sprintf("\n* and %d more", len(items)-maxPreviewItems)
+	}
+	return text, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterExpression(t *testing.T) {
+	fields := &ItemFields{
+		Title:      "Kubernetes 1.18.0-rc.1 released",
+		Content:    "Fixes CVE-2020-8555",
+		Author:     "Release Team",
+		Link:       "https://example.com/releases/1.18",
+		Categories: []ItemCategory{{Name: "Release"}, {Name: "Security"}},
+	}
+
+	for expression, expected := range map[string]bool{
+		`kubernetes`:            true,
+		`title:docker`:          false,
+		`title:"-rc"`:           true,
+		`content:/CVE-\d+-\d+/`: true,
+		`category:security AND author:"release team"`: true,
+		`category:security NOT title:rc`:              false,
+		`docker OR (link:releases AND NOT tag:beta)`:  true,
+		`"or"`: false,
+	} {
+		parsed, err := parseFilterExpression(expression)
+		if assert.NoError(t, err, expression) {
+			assert.Equal(t, expected, parsed.match(fields), expression)
+		}
+	}
+
+	for _, expression := range []string{``, `title:`, `(kubernetes`, `kubernetes)`, `/[/`, `"unclosed`, `NOT`, `a OR`} {
+		_, err := parseFilterExpression(expression)
+		assert.Error(t, err, expression)
+	}
+}
+
+func TestFilterItems(t *testing.T) {
+	sub := &Subscription{Filters: []*FilterRule{
+		{Mode: FilterInclude, Expression: "kubernetes"},
+		{Mode: FilterExclude, Expression: `title:"-rc"`},
+	}}
+
+	items := []*FeedItem{
+		{Key: "a", Fields: ItemFields{Title: "Kubernetes 1.18.0"}},
+		{Key: "b", Fields: ItemFields{Title: "Kubernetes 1.18.0-rc.1"}},
+		{Key: "c", Fields: ItemFields{Title: "Docker 19.03"}},
+	}
+
+	filtered := sub.filterItems(items)
+	assert.Len(t, filtered, 1)
+	assert.Equal(t, "a", filtered[0].Key)
+	filter := sub.compileFilters()
+	assert.Equal(t, sub.Filters[1], filter.rejection(&items[1].Fields))
+	assert.Equal(t, sub.Filters[0], filter.rejection(&items[2].Fields))
+}
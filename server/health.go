@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// recordFetchSuccess resets the failure count, telling the creator if they were notified about the failures
+func (p *RSSFeedPlugin) recordFetchSuccess(channelID string, sub *Subscription) {
+	notifyThreshold, _ := p.getFailureThresholds()
+
+	if notifyThreshold > 0 && sub.Failures >= notifyThreshold {
+		p.notifySubscriptionOwner(channelID, sub, fmt.Sprintf("The feed %s is working again after %d failed fetches.", sub.markdownLink(), sub.Failures))
+	}
+
+	sub.LastSuccess = time.Now().Unix()
+	sub.Failures = 0
+	sub.LastError = ""
+}
+
+// recordFetchFailure counts the failure, notifying the creator and pausing the subscription at the configured thresholds
+func (p *RSSFeedPlugin) recordFetchFailure(channelID string, sub *Subscription, err error) {
+	notifyThreshold, pauseThreshold := p.getFailureThresholds()
+
+	sub.Failures++
+	sub.LastError = err.Error()
+
+	if pauseThreshold > 0 && sub.Failures >= pauseThreshold {
+		sub.Paused = true
+		p.notifySubscriptionOwner(channelID, sub, fmt.Sprintf(
+			"The feed %s failed %d times in a row and has been paused, resume it with `/feed resume %d`. The last error was: `%s`",
+			sub.markdownLink(), sub.Failures, sub.ID, sub.LastError))
+		return
+	}
+
+	if notifyThreshold > 0 && sub.Failures == notifyThreshold {
+		p.notifySubscriptionOwner(channelID, sub, fmt.Sprintf(
+			"The feed %s failed %d times in a row, the last error was: `%s`",
+			sub.markdownLink(), sub.Failures, sub.LastError))
+	}
+}
+
+// recordFeedGone pauses a subscription to a feed the server says was removed on purpose
+func (p *RSSFeedPlugin) recordFeedGone(channelID string, sub *Subscription, err error) {
+	sub.Failures++
+	sub.LastError = err.Error()
+	sub.Paused = true
+
+	p.createBotPost(fmt.Sprintf(
+		"The feed %s no longer exists (410 Gone) and has been paused, use `/feed unsub` to remove it.",
+		sub.markdownLink()), channelID, "", "", nil)
+}
+
+// notifySubscriptionOwner sends a direct message to the user who created the subscription,
+// falling back to a post in the channel
+func (p *RSSFeedPlugin) notifySubscriptionOwner(channelID string, sub *Subscription, msg string) {
+	if sub.UserID != "" {
+		channel, err := p.API.GetDirectChannel(sub.UserID, p.botUserID)
+		if err == nil {
+			p.createBotPost(fmt.Sprintf("%s\nSubscribed in ~%s", msg, p.getChannelName(channelID)), channel.Id, "", "", nil)
+			return
+		}
+		p.API.LogError(err.Error())
+	}
+
+	p.createBotPost(msg, channelID, "", "", nil)
+}
+
+func (p *RSSFeedPlugin) getChannelName(channelID string) string {
+	channel, err := p.API.GetChannel(channelID)
+	if err != nil {
+		return channelID
+	}
+	return channel.Name
+}
+
+// healthFields describe the fetch health of a subscription in /feed list
+func healthFields(sub *Subscription) []*model.SlackAttachmentField {
+	status := "Active"
+	if sub.Paused {
+		status = "Paused"
+	}
+
+	lastSuccess := "never"
+	if sub.LastSuccess > 0 {
+		lastSuccess = time.Unix(sub.LastSuccess, 0).UTC().Format(time.RFC1123)
+	}
+
+	fields := []*model.SlackAttachmentField{
+		{
+			Title: "Status",
+			Value: status,
+			Short: true,
+		},
+		{
+			Title: "Last Success",
+			Value: lastSuccess,
+			Short: true,
+		},
+	}
+
+	if sub.Failures > 0 {
+		fields = append(fields, &model.SlackAttachmentField{
+			Title: fmt.Sprintf("Failures (%d)", sub.Failures),
+			Value: fmt.Sprintf("`%s`", sub.LastError),
+		})
+	}
+
+	return fields
+}
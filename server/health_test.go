@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// newHealthTestPlugin returns a plugin whose posts are collected in posts
+func newHealthTestPlugin(api *plugintest.API, client HTTPClient) (*RSSFeedPlugin, *[]*model.Post) {
+	posts := []*model.Post{}
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		posts = append(posts, post)
+		return post
+	}, nil)
+	api.On("LogError", mock.Anything).Return()
+
+	p := &RSSFeedPlugin{botUserID: "bot", FeedHandler: FeedHandlerDefault{client: client}}
+	p.SetAPI(api)
+	p.setConfiguration(&configuration{FailureNotifyThreshold: "2", FailurePauseThreshold: "3"})
+	return p, &posts
+}
+
+func TestRecordFetchFailure(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetDirectChannel", "owner", "bot").Return(&model.Channel{Id: "direct"}, nil)
+	api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", Name: "town-square"}, nil)
+	p, posts := newHealthTestPlugin(api, nil)
+
+	sub := &Subscription{ID: 1, Title: "Releases", URL: "https://example.com/feed", UserID: "owner"}
+	err := errors.New("connection refused")
+
+	// the owner is told once the notify threshold is reached
+	p.recordFetchFailure("channel", sub, err)
+	assert.Empty(t, *posts)
+	p.recordFetchFailure("channel", sub, err)
+	require.Len(t, *posts, 1)
+	assert.Equal(t, "direct", (*posts)[0].ChannelId)
+	assert.Equal(t, "The feed [Releases](https://example.com/feed) failed 2 times in a row, the last error was: `connection refused`\nSubscribed in ~town-square", (*posts)[0].Message)
+	assert.False(t, sub.Paused)
+
+	// and again when the subscription is paused
+	p.recordFetchFailure("channel", sub, err)
+	require.Len(t, *posts, 2)
+	assert.Contains(t, (*posts)[1].Message, "failed 3 times in a row and has been paused, resume it with `/feed resume 1`")
+	assert.True(t, sub.Paused)
+	assert.Equal(t, 3, sub.Failures)
+
+	// working again after the owner was notified
+	p.recordFetchSuccess("channel", sub)
+	require.Len(t, *posts, 3)
+	assert.Contains(t, (*posts)[2].Message, "is working again after 3 failed fetches")
+	assert.Zero(t, sub.Failures)
+	assert.Empty(t, sub.LastError)
+	assert.NotZero(t, sub.LastSuccess)
+
+	// without an owner the channel is notified
+	sub = &Subscription{ID: 2, Title: "News", URL: "https://example.com/news"}
+	p.recordFetchFailure("channel", sub, err)
+	p.recordFetchFailure("channel", sub, err)
+	require.Len(t, *posts, 4)
+	assert.Equal(t, "channel", (*posts)[3].ChannelId)
+}
+
+func TestProcessSubscriptionPausesGoneFeeds(t *testing.T) {
+	api := &plugintest.API{}
+	p, posts := newHealthTestPlugin(api, clientFunc(func(req *http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: http.StatusGone, Status: "410 Gone", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	}))
+
+	sub := &Subscription{ID: 1, Title: "Releases", URL: "https://example.com/feed", Format: FeedFormatRSSV2}
+	p.processSubscription(context.Background(), "channel", sub)
+
+	assert.True(t, sub.Paused)
+	assert.Equal(t, 1, sub.Failures)
+	assert.Equal(t, "https://example.com/feed responded with 410 Gone", sub.LastError)
+	require.Len(t, *posts, 1)
+	assert.Equal(t, "channel", (*posts)[0].ChannelId)
+	assert.Contains(t, (*posts)[0].Message, "no longer exists (410 Gone) and has been paused")
+}
+
+func TestProcessSubscriptionWithFreshCache(t *testing.T) {
+	api := &plugintest.API{}
+	p, posts := newHealthTestPlugin(api, clientFunc(func(req *http.Request) (*http.Response, error) {
+		t.Fatal("a fresh response was fetched again")
+		return nil, nil
+	}))
+
+	// nothing was fetched, so the failures are neither reset nor counted
+	sub := &Subscription{
+		ID:         1,
+		Title:      "Releases",
+		URL:        "https://example.com/feed",
+		Format:     FeedFormatRSSV2,
+		CacheUntil: time.Now().Add(time.Hour).Unix(),
+		Failures:   2,
+		LastError:  "connection refused",
+	}
+	p.processSubscription(context.Background(), "channel", sub)
+
+	assert.Equal(t, 2, sub.Failures)
+	assert.Equal(t, "connection refused", sub.LastError)
+	assert.Zero(t, sub.LastSuccess)
+	assert.Equal(t, int64(1), sub.CacheStats.Fresh)
+	assert.Empty(t, *posts)
+}
+
+func TestHandleResume(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	p, posts := newHealthTestPlugin(api, nil)
+
+	require.NoError(t, p.addSubscription("channel", &Subscription{
+		ID:        1,
+		Title:     "Releases",
+		URL:       "https://example.com/feed",
+		Paused:    true,
+		Failures:  3,
+		LastError: "connection refused",
+	}))
+
+	p.handleResume("1", &model.CommandArgs{ChannelId: "channel"})
+
+	subs, err := p.getSubscriptions("channel")
+	require.NoError(t, err)
+	sub := subs.Subscriptions[0]
+	assert.False(t, sub.Paused)
+	assert.Zero(t, sub.Failures)
+	assert.Empty(t, sub.LastError)
+	require.Len(t, *posts, 1)
+	assert.Equal(t, "Resumed Releases", (*posts)[0].Message)
+}
@@ -0,0 +1,138 @@
+/*
+structures have been defined using the JSON Feed specification
+https://www.jsonfeed.org/version/1.1/
+
+Version 1.0 feeds are also accepted, the singular author field is
+folded into Authors when the feed is parsed.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// JSONFeedVersionPrefix - every valid feed has a version url starting with this
+const JSONFeedVersionPrefix = "https://jsonfeed.org/version/"
+
+// JSONFeed - top level object of a JSON Feed document
+type JSONFeed struct {
+	Version     string            `json:"version"`
+	Title       string            `json:"title"`
+	HomePageURL string            `json:"home_page_url"`
+	FeedURL     string            `json:"feed_url"`
+	Description string            `json:"description"`
+	Icon        string            `json:"icon"`
+	Favicon     string            `json:"favicon"`
+	Authors     []*JSONFeedAuthor `json:"authors"`
+	Author      *JSONFeedAuthor   `json:"author"` // deprecated in 1.1
+	Language    string            `json:"language"`
+	Expired     bool              `json:"expired"`
+	Hubs        []*JSONFeedHub    `json:"hubs"`
+	Items       []*JSONFeedItem   `json:"items"`
+}
+
+// JSONFeedItem - an entry in the items array
+type JSONFeedItem struct {
+	ID            string                `json:"id"`
+	URL           string                `json:"url"`
+	ExternalURL   string                `json:"external_url"`
+	Title         string                `json:"title"`
+	ContentHTML   string                `json:"content_html"`
+	ContentText   string                `json:"content_text"`
+	Summary       string                `json:"summary"`
+	Image         string                `json:"image"`
+	BannerImage   string                `json:"banner_image"`
+	DatePublished string                `json:"date_published"`
+	DateModified  string                `json:"date_modified"`
+	Authors       []*JSONFeedAuthor     `json:"authors"`
+	Author        *JSONFeedAuthor       `json:"author"` // deprecated in 1.1
+	Tags          []string              `json:"tags"`
+	Language      string                `json:"language"`
+	Attachments   []*JSONFeedAttachment `json:"attachments"`
+}
+
+// JSONFeedAuthor - author object, all fields are optional
+type JSONFeedAuthor struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Avatar string `json:"avatar"`
+}
+
+// JSONFeedHub - endpoint that can be used to subscribe to real-time notifications, e.g. WebSub
+type JSONFeedHub struct {
+	Type string `json:"type"`
+	URL  string `json:"url"`
+}
+
+// JSONFeedAttachment - related resource of an item, such as a podcast episode
+type JSONFeedAttachment struct {
+	URL               string `json:"url"`
+	MimeType          string `json:"mime_type"`
+	Title             string `json:"title"`
+	SizeInBytes       int64  `json:"size_in_bytes"`
+	DurationInSeconds int64  `json:"duration_in_seconds"`
+}
+
+// JSONFeedParseString will be used to parse strings and will return the JSONFeed object
+func JSONFeedParseString(s string) (*JSONFeed, error) {
+	feed := JSONFeed{}
+	if len(s) == 0 {
+		return &feed, nil
+	}
+
+	err := json.Unmarshal([]byte(s), &feed)
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(feed.Version, JSONFeedVersionPrefix) {
+		return nil, errors.New("not a json feed")
+	}
+
+	if len(feed.Authors) == 0 && feed.Author != nil {
+		feed.Authors = []*JSONFeedAuthor{feed.Author}
+	}
+	for _, item := range feed.Items {
+		if len(item.Authors) == 0 && item.Author != nil {
+			item.Authors = []*JSONFeedAuthor{item.Author}
+		}
+	}
+
+	return &feed, nil
+}
+
+// Fingerprint - identifies the item in the seen item index, id is required by the spec
+func (item *JSONFeedItem) Fingerprint() string {
+	if item.ID != "" {
+		return itemFingerprint(item.ID)
+	}
+	return itemFingerprint(item.URL)
+}
+
+// Revision - changes whenever date_modified, the title or the url of the item changes,
+// the content is left out like in every format
+func (item *JSONFeedItem) Revision() string {
+	return itemFingerprint(item.DateModified, item.Title, item.URL)
+}
+
+// Timestamp - unix time of the last modification of the item,
+// falling back to the publish date
+func (item *JSONFeedItem) Timestamp() int64 {
+	if item.DateModified != "" {
+		return JSONFeedParseTimestamp(item.DateModified)
+	}
+	return JSONFeedParseTimestamp(item.DatePublished)
+}
+
+// JSONFeedParseTimestamp - turn an RFC 3339 date into a unix timestamp
+func JSONFeedParseTimestamp(str string) int64 {
+	t, err := time.Parse(time.RFC3339, str)
+	if err != nil {
+		return 0
+	}
+	return t.Unix()
+}
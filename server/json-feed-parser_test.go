@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const jsonFeedSample = `{
+	"version": "https://jsonfeed.org/version/1",
+	"title": "Example",
+	"home_page_url": "https://example.com/",
+	"author": {"name": "Example Author"},
+	"items": [
+		{
+			"id": "2",
+			"url": "https://example.com/2",
+			"title": "Second",
+			"content_text": "second item",
+			"date_published": "2020-04-21T10:00:00Z"
+		},
+		{
+			"id": "1",
+			"url": "https://example.com/1",
+			"title": "First",
+			"content_html": "<p>first item</p>",
+			"date_published": "2020-04-20T10:00:00Z",
+			"authors": [{"name": "Item Author"}]
+		}
+	]
+}`
+
+func TestJSONFeedParseString(t *testing.T) {
+	feed, err := JSONFeedParseString(jsonFeedSample)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Example", feed.Title)
+	require.Len(t, feed.Authors, 1)
+	assert.Equal(t, "Example Author", feed.Authors[0].Name)
+	require.Len(t, feed.Items, 2)
+	assert.Equal(t, "Item Author", feed.Items[1].Authors[0].Name)
+	assert.Equal(t, JSONFeedParseTimestamp("2020-04-21T10:00:00Z"), feed.Items[0].Timestamp())
+	assert.Equal(t, itemFingerprint("2"), feed.Items[0].Fingerprint())
+
+	_, err = JSONFeedParseString(`{"title": "not a feed"}`)
+	assert.Error(t, err)
+}
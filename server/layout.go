@@ -0,0 +1,206 @@
+/*
+Post layouts
+
+Items are posted as attachments unless the subscription chose another layout with /feed layout.
+Content of attachments isn't found by search and long attachments take a lot of space on mobile,
+"markdown" posts every item as the message of its own post, so it is searchable and gets a link preview,
+"compact" posts a single bullet line per item, as few posts per poll as the message length limit allows.
+The line only has the title, the link and the hashtags of the item, templates and the categories field
+don't show in compact posts.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// post layouts
+const (
+	LayoutAttachment = "attachment"
+	LayoutMarkdown   = "markdown"
+	LayoutCompact    = "compact"
+)
+
+// updatedMarkdown is appended to messages edited because the item changed
+const updatedMarkdown = "_(updated)_"
+
+func isLayout(layout string) bool {
+	return layout == LayoutAttachment || layout == LayoutMarkdown || layout == LayoutCompact
+}
+
+// PostLayout - how items are posted, attachments unless set otherwise
+func (s *Subscription) PostLayout() string {
+	if s.Layout == "" {
+		return LayoutAttachment
+	}
+	return s.Layout
+}
+
+// escapeLinkText escapes the brackets of text used in a markdown link
+func escapeLinkText(text string) string {
+	return strings.NewReplacer("[", "\\[", "]", "\\]").Replace(text)
+}
+
+// itemLink - the title of the item linking to it
+func itemLink(attachment *model.SlackAttachment) string {
+	title := strings.TrimSpace(attachment.Title)
+	switch {
+	case attachment.TitleLink == "":
+		return title
+	case title == "":
+		return attachment.TitleLink
+	}
+	return fmt.Sprintf("[%s](%s)", escapeLinkText(title), attachment.TitleLink)
+}
+
+// truncateRunes cuts text to at most limit runes
+func truncateRunes(text string, limit int) string {
+	if utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+	return truncateText(limit, text)
+}
+
+// markdownMessage renders the message from the template, the attachment of the item and its hashtags
+// as a post message
+func markdownMessage(item *FeedItem, config *configuration) string {
+	attachment := item.Attachment
+
+	head := []string{}
+	if item.Message != "" {
+		head = append(head, item.Message, "")
+	}
+	head = append(head, "#### "+itemLink(attachment))
+	if attachment.AuthorName != "" {
+		head = append(head, fmt.Sprintf("_by %s_", attachment.AuthorName))
+	}
+
+	tail := []string{}
+	if attachment.ImageURL != "" {
+		tail = append(tail, fmt.Sprintf("![%s](%s)", escapeLinkText(attachment.Title), attachment.ImageURL))
+	}
+	for _, field := range attachment.Fields {
+		tail = append(tail, fmt.Sprintf("**%s**: %v", field.Title, field.Value))
+	}
+	if tags := hashtagMessage([]*FeedItem{item}, config); tags != "" {
+		tail = append(tail, tags)
+	}
+
+	// the text gives way if the message gets too long
+	text := strings.TrimSpace(attachment.Text)
+	if text != "" {
+		length := utf8.RuneCountInString(strings.Join(head, "\n") + strings.Join(tail, "\n"))
+		text = truncateRunes(text, model.POST_MESSAGE_MAX_RUNES_V2-length-len(head)-len(tail)-2)
+	}
+
+	lines := head
+	if text != "" {
+		lines = append(lines, "", text)
+	}
+	if len(tail) > 0 {
+		lines = append(lines, "")
+		lines = append(lines, tail...)
+	}
+	return truncateRunes(strings.Join(lines, "\n"), model.POST_MESSAGE_MAX_RUNES_V2)
+}
+
+// compactLine renders the item as a single bullet line, the output of the template and
+// the fields of the attachment are left out
+func compactLine(item *FeedItem, config *configuration) string {
+	line := "* " + strings.Join(strings.Fields(itemLink(item.Attachment)), " ")
+	if tags := hashtagMessage([]*FeedItem{item}, config); tags != "" {
+		line += " " + tags
+	}
+	return truncateRunes(line, model.POST_MESSAGE_MAX_RUNES_V2)
+}
+
+// splitLines groups lines into messages of at most limit runes
+func splitLines(lines []string, limit int) [][]string {
+	groups := [][]string{}
+	group := []string{}
+	length := 0
+	for _, line := range lines {
+		lineLength := utf8.RuneCountInString(line)
+		if len(group) > 0 && length+1+lineLength > limit {
+			groups = append(groups, group)
+			group = []string{}
+			length = 0
+		}
+		if len(group) > 0 {
+			length++
+		}
+		group = append(group, line)
+		length += lineLength
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// postMarkdown posts every item as the message of its own post
+func (p *RSSFeedPlugin) postMarkdown(channelID string, sub *Subscription, items []*FeedItem, config *configuration) {
+	for _, item := range items {
+		post := p.createItemPost(channelID, sub, markdownMessage(item, config), nil)
+		if post == nil {
+			continue
+		}
+		sub.markPosted(item.Key, post.Id, 0, LayoutMarkdown)
+	}
+}
+
+// postCompact posts a line per item in as few posts as possible
+func (p *RSSFeedPlugin) postCompact(channelID string, sub *Subscription, items []*FeedItem, config *configuration) {
+	lines := make([]string, len(items))
+	for index, item := range items {
+		lines[index] = compactLine(item, config)
+	}
+
+	posted := 0
+	for _, group := range splitLines(lines, model.POST_MESSAGE_MAX_RUNES_V2) {
+		groupItems := items[posted : posted+len(group)]
+		posted += len(group)
+
+		post := p.createItemPost(channelID, sub, strings.Join(group, "\n"), nil)
+		if post == nil {
+			continue
+		}
+		for index, item := range groupItems {
+			sub.markPosted(item.Key, post.Id, index, LayoutCompact)
+		}
+	}
+}
+
+// editMessage updates the message of a post made with the markdown or compact layout
+func editMessage(post *model.Post, item *FeedItem, config *configuration) error {
+	switch item.Layout {
+	case LayoutMarkdown:
+		post.Message = markdownMessage(item, config)
+		if config.MarkUpdates {
+			post.Message = truncateRunes(post.Message+"\n"+updatedMarkdown, model.POST_MESSAGE_MAX_RUNES_V2)
+		}
+
+	case LayoutCompact:
+		lines := strings.Split(post.Message, "\n")
+		if item.Index < 0 || item.Index >= len(lines) {
+			return fmt.Errorf("post has no line %d", item.Index)
+		}
+		lines[item.Index] = compactLine(item, config)
+		if config.MarkUpdates {
+			lines[item.Index] += " " + updatedMarkdown
+		}
+		post.Message = strings.Join(lines, "\n")
+		if utf8.RuneCountInString(post.Message) > model.POST_MESSAGE_MAX_RUNES_V2 {
+			return fmt.Errorf("the updated post would be too long")
+		}
+
+	default:
+		return fmt.Errorf("unknown layout %s", item.Layout)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitLines(t *testing.T) {
+	lines := []string{"aaaa", "bbbb", "cccc", "dddddddddddd"}
+	assert.Equal(t, [][]string{{"aaaa", "bbbb"}, {"cccc"}, {"dddddddddddd"}}, splitLines(lines, 10))
+	assert.Equal(t, [][]string{lines}, splitLines(lines, 100))
+	assert.Empty(t, splitLines(nil, 10))
+}
+
+func TestMarkdownMessage(t *testing.T) {
+	item := &FeedItem{
+		Attachment: &model.SlackAttachment{
+			Title:      "Release [1.18]",
+			TitleLink:  "https://example.com/1.18",
+			AuthorName: "Release Team",
+			Text:       "Notes",
+			Fields:     []*model.SlackAttachmentField{{Title: "Download", Value: "https://example.com/1.18.tar.gz"}},
+		},
+		Fields: ItemFields{Categories: []ItemCategory{{Name: "Release"}}},
+	}
+	config := &configuration{ShowCategories: CategoriesHashtags}
+	assert.Equal(t, "#### [Release \\[1.18\\]](https://example.com/1.18)\n_by Release Team_\n\nNotes\n\n**Download**: https://example.com/1.18.tar.gz\n#release", markdownMessage(item, config))
+
+	item.Attachment.Text = strings.Repeat("long ", model.POST_MESSAGE_MAX_RUNES_V2)
+	message := markdownMessage(item, config)
+	assert.True(t, len([]rune(message)) <= model.POST_MESSAGE_MAX_RUNES_V2)
+	assert.True(t, strings.HasSuffix(message, "**Download**: https://example.com/1.18.tar.gz\n#release"))
+}
+
+func TestEditCompactMessage(t *testing.T) {
+	config := &configuration{MarkUpdates: true}
+	post := &model.Post{Message: "* [a](https://example.com/a)\n* [b](https://example.com/b)"}
+	item := &FeedItem{
+		Attachment: &model.SlackAttachment{Title: "b, edited", TitleLink: "https://example.com/b"},
+		Layout:     LayoutCompact,
+		Index:      1,
+	}
+
+	assert.NoError(t, editMessage(post, item, config))
+	assert.Equal(t, "* [a](https://example.com/a)\n* [b, edited](https://example.com/b) _(updated)_", post.Message)
+
+	item.Index = 2
+	assert.Error(t, editMessage(post, item, config))
+}
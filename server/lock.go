@@ -0,0 +1,228 @@
+/*
+Locks shared by all nodes of a cluster
+
+Every node of a high availability cluster runs the plugin, so the nodes agree on a single poller
+through a lease in the KV store. The lease expires when its holder stops renewing it,
+another node takes over within pollerLeaseTTL if the poller goes away without releasing it.
+
+Fetching a channel takes a lock of the channel as well, so manual fetches and pushed content
+never process the same subscriptions as the poller at the same time.
+
+Both are renewed by keepLock while work is in progress, however long fetching takes,
+the work is cancelled if the lease was lost anyway, e.g. because the KV store couldn't be reached.
+
+The plugin API of the supported server versions has no cluster mutex,
+the locks are built on KVCompareAndSet which requires 5.12.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	pollerLockKey = metaKeyPrefix + "lock_poller"
+	// the poller renews its lease every schedulerTick and while processing a heartbeat
+	pollerLeaseTTL = 3 * schedulerTick
+
+	// renewed while the channel is fetched, the lock is released when done
+	channelLockTTL = 3 * time.Minute
+	// pushed content waits this long for a running fetch of the channel
+	channelLockWait = 30 * time.Second
+)
+
+// errChannelBusy is returned when another fetch of the channel is running
+var errChannelBusy = errors.New("the feeds of this channel are already being fetched")
+
+// clock - the time source of locks, tests replace it so leases expire without waiting
+type clock interface {
+	Now() time.Time
+	// Ticker delivers a tick every d until stop is called
+	Ticker(d time.Duration) (ticks <-chan time.Time, stop func())
+}
+
+// systemClock - the clock of locks unless the plugin was given another one
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// getLockClock returns the clock used for leases
+func (p *RSSFeedPlugin) getLockClock() clock {
+	if p.lockClock == nil {
+		return systemClock{}
+	}
+	return p.lockClock
+}
+
+// kvLease - the value of a lock, it is free once expired
+type kvLease struct {
+	Holder  string
+	Expires int64 // unix time
+}
+
+// tryLock takes or renews the lock for holder, returns false if someone else holds it
+func (p *RSSFeedPlugin) tryLock(key string, holder string, ttl time.Duration) (bool, error) {
+	current, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return false, appErr
+	}
+
+	now := p.getLockClock().Now()
+	if current != nil {
+		lease := kvLease{}
+		if err := json.Unmarshal(current, &lease); err != nil {
+			return false, err
+		}
+		if lease.Holder != holder && lease.Expires > now.Unix() {
+			return false, nil
+		}
+	}
+
+	next, err := json.Marshal(kvLease{Holder: holder, Expires: now.Add(ttl).Unix()})
+	if err != nil {
+		return false, err
+	}
+
+	// nil only matches if the key doesn't exist
+	ok, appErr := p.API.KVCompareAndSet(key, current, next)
+	if appErr != nil {
+		return false, appErr
+	}
+	return ok, nil
+}
+
+// unlock releases the lock if holder still holds it
+func (p *RSSFeedPlugin) unlock(key string, holder string) error {
+	current, appErr := p.API.KVGet(key)
+	if appErr != nil {
+		return appErr
+	}
+	if current == nil {
+		return nil
+	}
+
+	lease := kvLease{}
+	if err := json.Unmarshal(current, &lease); err != nil {
+		return err
+	}
+	if lease.Holder != holder {
+		return nil
+	}
+
+	released, err := json.Marshal(kvLease{})
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(current, released) {
+		return nil
+	}
+
+	if _, appErr := p.API.KVCompareAndSet(key, current, released); appErr != nil {
+		return appErr
+	}
+	return nil
+}
+
+// isPoller takes or renews the poller lease, only the node holding it polls feeds
+func (p *RSSFeedPlugin) isPoller() bool {
+	ok, err := p.tryLock(pollerLockKey, p.nodeID, pollerLeaseTTL)
+	if err != nil {
+		p.API.LogError("Failed to take the poller lease", "err", err.Error())
+		return false
+	}
+	return ok
+}
+
+// keepLock renews the lock held by holder every third of ttl until stop is called,
+// the returned context is cancelled once the lock was lost
+func (p *RSSFeedPlugin) keepLock(ctx context.Context, key string, holder string, ttl time.Duration) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticks, stopTicks := p.getLockClock().Ticker(ttl / 3)
+		defer stopTicks()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticks:
+			}
+
+			ok, err := p.tryLock(key, holder, ttl)
+			if err != nil {
+				// the lease is still valid for a while, try again with the next tick
+				p.API.LogError("Failed to renew lock", "key", key, "err", err.Error())
+				continue
+			}
+			if !ok {
+				p.API.LogWarn("Lost lock, stopping", "key", key)
+				cancel()
+				return
+			}
+		}
+	}()
+
+	stop := func() {
+		cancel()
+		<-done
+	}
+	return ctx, stop
+}
+
+// withChannelLock runs f while holding the lock of the channel, waiting up to wait for it,
+// returns errChannelBusy if the lock couldn't be taken in time.
+// The context passed to f is cancelled along with ctx or if the lock was lost
+func (p *RSSFeedPlugin) withChannelLock(ctx context.Context, channelID string, wait time.Duration, f func(ctx context.Context)) error {
+	key := channelKey(channelID, "lock")
+	// every fetch holds the lock on its own, even on the same node
+	holder := model.NewId()
+
+	deadline := time.Now().Add(wait)
+	for {
+		ok, err := p.tryLock(key, holder, channelLockTTL)
+		if err != nil {
+			return err
+		}
+		if ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			return errChannelBusy
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+
+	defer func() {
+		if err := p.unlock(key, holder); err != nil {
+			p.API.LogError("Failed to release channel lock", "channel_id", channelID, "err", err.Error())
+		}
+	}()
+
+	ctx, stop := p.keepLock(ctx, key, holder, channelLockTTL)
+	defer stop()
+
+	f(ctx)
+	return nil
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// kvStore - an in memory KV store behind a plugintest.API
+type kvStore struct {
+	sync.Mutex
+	values map[string][]byte
+}
+
+func (s *kvStore) get(key string) []byte {
+	s.Lock()
+	defer s.Unlock()
+	return s.values[key]
+}
+
+func (s *kvStore) set(key string, value []byte) {
+	s.Lock()
+	defer s.Unlock()
+	if value == nil {
+		delete(s.values, key)
+		return
+	}
+	s.values[key] = value
+}
+
+// newKVTestAPI returns an API with a working KV store, logging is ignored
+func newKVTestAPI(values map[string][]byte) (*plugintest.API, *kvStore) {
+	store := &kvStore{values: values}
+	api := &plugintest.API{}
+
+	api.On("KVGet", mock.Anything).Return(func(key string) []byte {
+		return store.get(key)
+	}, nil)
+	api.On("KVSet", mock.Anything, mock.Anything).Return(func(key string, value []byte) *model.AppError {
+		store.set(key, value)
+		return nil
+	})
+	api.On("KVDelete", mock.Anything).Return(func(key string) *model.AppError {
+		store.set(key, nil)
+		return nil
+	})
+	api.On("KVCompareAndSet", mock.Anything, mock.Anything, mock.Anything).Return(func(key string, oldValue, newValue []byte) bool {
+		store.Lock()
+		defer store.Unlock()
+		current, exists := store.values[key]
+		if (oldValue == nil && exists) || (oldValue != nil && !bytes.Equal(current, oldValue)) {
+			return false
+		}
+		store.values[key] = newValue
+		return true
+	}, nil)
+	api.On("KVList", mock.Anything, mock.Anything).Return(func(page, perPage int) []string {
+		store.Lock()
+		defer store.Unlock()
+		keys := []string{}
+		for key := range store.values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		start, end := page*perPage, (page+1)*perPage
+		if start > len(keys) {
+			return []string{}
+		}
+		if end > len(keys) {
+			end = len(keys)
+		}
+		return keys[start:end]
+	}, nil)
+	for _, level := range []string{"LogDebug", "LogInfo", "LogWarn", "LogError"} {
+		api.On(level, mock.Anything).Maybe()
+		api.On(level, mock.Anything, mock.Anything, mock.Anything).Maybe()
+		api.On(level, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+		api.On(level, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Maybe()
+	}
+	return api, store
+}
+
+// fakeClock - a clock whose time and ticks are controlled by the test
+type fakeClock struct {
+	sync.Mutex
+	now   time.Time
+	ticks chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(1560000000, 0), ticks: make(chan time.Time)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Ticker(d time.Duration) (<-chan time.Time, func()) {
+	return c.ticks, func() {}
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// tick blocks until the ticker was read
+func (c *fakeClock) tick() {
+	c.ticks <- c.Now()
+}
+
+func TestKeepLock(t *testing.T) {
+	api, store := newKVTestAPI(map[string][]byte{})
+	clock := newFakeClock()
+	p := &RSSFeedPlugin{lockClock: clock}
+	p.SetAPI(api)
+
+	const ttl = time.Minute
+	ok, err := p.tryLock("lock", "a", ttl)
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	// renewed past the original expiry,
+	// the second tick is only read once the renewal of the first is done
+	ctx, stop := p.keepLock(context.Background(), "lock", "a", ttl)
+	clock.advance(50 * time.Second)
+	clock.tick()
+	clock.tick()
+	clock.advance(50 * time.Second)
+	ok, err = p.tryLock("lock", "b", ttl)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.NoError(t, ctx.Err())
+	stop()
+	assert.Error(t, ctx.Err())
+
+	// losing the lock cancels the work
+	ctx, stop = p.keepLock(context.Background(), "lock", "a", ttl)
+	defer stop()
+	stolen, _ := json.Marshal(kvLease{Holder: "b", Expires: clock.Now().Add(time.Hour).Unix()})
+	store.set("lock", stolen)
+	clock.tick()
+	select {
+	case <-ctx.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("the context wasn't cancelled after the lock was lost")
+	}
+}
@@ -7,5 +7,5 @@ var manifest = struct {
 	Version string
 }{
 	ID:      "rssfeed",
-	Version: "0.3.0",
+	Version: "0.4.0",
 }
@@ -0,0 +1,95 @@
+/*
+structures have been defined using the OPML 2.0 specification
+http://opml.org/spec2.opml
+
+Only the subscription list flavour of OPML is of interest,
+outlines of type "rss" carry the feed url in the xmlUrl attribute
+and may be nested inside folder outlines.
+
+Exports add the plugin specific settings of a subscription as
+attributes in the https://github.com/trobol/mattermost-plugin-rssfeed namespace.
+*/
+
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+
+	"golang.org/x/net/html/charset"
+)
+
+// OPML - <opml> is the root element of the document
+type OPML struct {
+	XMLName xml.Name `xml:"opml"`
+	Version string   `xml:"version,attr"`
+	Head    OPMLHead `xml:"head"`
+	Body    OPMLBody `xml:"body"`
+}
+
+// OPMLHead - metadata of the document
+type OPMLHead struct {
+	Title       string `xml:"title,omitempty"`
+	DateCreated string `xml:"dateCreated,omitempty"`
+	OwnerName   string `xml:"ownerName,omitempty"`
+	Docs        string `xml:"docs,omitempty"`
+}
+
+// OPMLBody - contains one or more outlines
+type OPMLBody struct {
+	Outlines []*OPMLOutline `xml:"outline"`
+}
+
+// OPMLOutline - either a folder holding more outlines or a subscription
+type OPMLOutline struct {
+	Text     string         `xml:"text,attr"`
+	Title    string         `xml:"title,attr,omitempty"`
+	Type     string         `xml:"type,attr,omitempty"`
+	XMLURL   string         `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL  string         `xml:"htmlUrl,attr,omitempty"`
+	Outlines []*OPMLOutline `xml:"outline"`
+
+	Format  string `xml:"https://github.com/trobol/mattermost-plugin-rssfeed format,attr,omitempty"`
+	Color   string `xml:"https://github.com/trobol/mattermost-plugin-rssfeed color,attr,omitempty"`
+	Creator string `xml:"https://github.com/trobol/mattermost-plugin-rssfeed creator,attr,omitempty"`
+}
+
+// OPMLParseString will be used to parse strings and will return the OPML object
+func OPMLParseString(s string) (*OPML, error) {
+	opml := OPML{}
+	if len(s) == 0 {
+		return &opml, nil
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	decoder.CharsetReader = charset.NewReaderLabel
+	err := decoder.Decode(&opml)
+	if err != nil {
+		return nil, err
+	}
+	return &opml, nil
+}
+
+// OPMLString - encodes the document with an xml header
+func (opml *OPML) OPMLString() (string, error) {
+	b, err := xml.MarshalIndent(opml, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return xml.Header + string(b), nil
+}
+
+// Feeds - all outlines with an xmlUrl, including the ones inside folders
+func (opml *OPML) Feeds() []*OPMLOutline {
+	return collectFeedOutlines(opml.Body.Outlines, []*OPMLOutline{})
+}
+
+func collectFeedOutlines(outlines []*OPMLOutline, feeds []*OPMLOutline) []*OPMLOutline {
+	for _, outline := range outlines {
+		if outline.XMLURL != "" {
+			feeds = append(feeds, outline)
+		}
+		feeds = collectFeedOutlines(outline.Outlines, feeds)
+	}
+	return feeds
+}
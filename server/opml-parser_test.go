@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOPMLFeeds(t *testing.T) {
+	opml, err := OPMLParseString(`<?xml version="1.0"?>
+<opml version="2.0">
+	<head><title>Reading list</title></head>
+	<body>
+		<outline text="Top" type="rss" xmlUrl="https://example.com/top.xml"/>
+		<outline text="Folder">
+			<outline text="Nested" type="rss" xmlUrl="https://example.com/nested.xml"/>
+			<outline text="Deeper">
+				<outline text="Deepest" type="rss" xmlUrl="https://example.com/deepest.xml"/>
+			</outline>
+		</outline>
+	</body>
+</opml>`)
+	require.NoError(t, err)
+
+	feeds := opml.Feeds()
+	require.Len(t, feeds, 3)
+	assert.Equal(t, "https://example.com/top.xml", feeds[0].XMLURL)
+	assert.Equal(t, "https://example.com/nested.xml", feeds[1].XMLURL)
+	assert.Equal(t, "https://example.com/deepest.xml", feeds[2].XMLURL)
+}
+
+func TestOPMLStringRoundTrip(t *testing.T) {
+	opml := &OPML{
+		Version: "2.0",
+		Head:    OPMLHead{Title: "Feeds"},
+		Body: OPMLBody{Outlines: []*OPMLOutline{{
+			Text:    "Example",
+			Type:    "rss",
+			XMLURL:  "https://example.com/feed.xml",
+			HTMLURL: "https://example.com/",
+			Format:  FeedFormatAtom.String(),
+			Color:   "#123456",
+			Creator: "someone",
+		}}},
+	}
+
+	body, err := opml.OPMLString()
+	require.NoError(t, err)
+
+	parsed, err := OPMLParseString(body)
+	require.NoError(t, err)
+	assert.Equal(t, "Feeds", parsed.Head.Title)
+	require.Len(t, parsed.Feeds(), 1)
+	assert.Equal(t, *opml.Body.Outlines[0], *parsed.Feeds()[0])
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// number of recent channel posts searched for an uploaded opml file
+const opmlPostSearchLimit = 30
+
+// colors of exported subscriptions are restored on import, see OPMLOutline.Color
+var opmlColor = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// importOPML subscribes the channel to every feed in the opml document.
+// When url is empty the most recent opml file the user uploaded to the channel is used.
+func (p *RSSFeedPlugin) importOPML(ctx context.Context, url string, channelID string, userID string) {
+	var opml *OPML
+	var err error
+
+	if url != "" {
+		opml, err = p.FetchOPML(withFetchPool(ctx, p.getFetchPool()), url)
+	} else {
+		opml, err = p.findUploadedOPML(channelID, userID)
+	}
+
+	if err != nil {
+		p.API.LogError(err.Error())
+		p.createBotPost(fmt.Sprintf("Failed to import OPML: `%s`", err.Error()), channelID, userID, "", nil)
+		return
+	}
+
+	feeds := opml.Feeds()
+	if len(feeds) == 0 {
+		p.createBotPost("No feeds were found in the OPML document", channelID, userID, "", nil)
+		return
+	}
+
+	subList, err := p.getSubscriptions(channelID)
+	if err != nil {
+		p.createBotPost(fmt.Sprintf("Failed to import OPML: `%s`", err.Error()), channelID, userID, "", nil)
+		return
+	}
+
+	subscribed := []string{}
+	duplicates := []string{}
+	failures := []string{}
+
+	for _, feed := range feeds {
+		if ctx.Err() != nil {
+			// the plugin is stopping, the feeds after this one weren't tried
+			failures = append(failures, fmt.Sprintf("%s: `%s`", feed.XMLURL, ctx.Err().Error()))
+			continue
+		}
+		if sub, _ := subList.find(feed.XMLURL); sub != nil {
+			duplicates = append(duplicates, sub.Title)
+			continue
+		}
+
+		sub, _, err := p.createSubscription(ctx, feed.XMLURL, channelID, userID)
+		switch {
+		case err == errAlreadySubscribed:
+			duplicates = append(duplicates, feed.XMLURL)
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: `%s`", feed.XMLURL, err.Error()))
+		default:
+			subscribed = append(subscribed, sub.Title)
+			p.restoreOPMLColor(channelID, sub, feed)
+		}
+	}
+
+	attachment := &model.SlackAttachment{
+		Title: fmt.Sprintf("Imported %d of %d feeds", len(subscribed), len(feeds)),
+	}
+	for _, group := range []struct {
+		title string
+		feeds []string
+	}{
+		{"Subscribed", subscribed},
+		{"Already subscribed", duplicates},
+		{"Failed", failures},
+	} {
+		if len(group.feeds) == 0 {
+			continue
+		}
+		attachment.Fields = append(attachment.Fields, &model.SlackAttachmentField{
+			Title: fmt.Sprintf("%s (%d)", group.title, len(group.feeds)),
+			Value: strings.Join(group.feeds, "\n"),
+		})
+	}
+
+	p.createBotPost("OPML import finished:", channelID, "", "", []*model.SlackAttachment{attachment})
+}
+
+// restoreOPMLColor gives the new subscription the color it had when it was exported
+func (p *RSSFeedPlugin) restoreOPMLColor(channelID string, sub *Subscription, feed *OPMLOutline) {
+	if !opmlColor.MatchString(feed.Color) || feed.Color == sub.Color {
+		return
+	}
+
+	err := p.updateSubscription(channelID, sub.ID, func(sub *Subscription) error {
+		sub.Color = feed.Color
+		return nil
+	})
+	if err != nil {
+		p.API.LogWarn("Failed to restore the color of an imported subscription", "url", sub.URL, "err", err.Error())
+	}
+}
+
+// findUploadedOPML looks through the latest posts of the channel for an opml file the user uploaded,
+// slash commands can't carry attachments so the file has to be posted first
+func (p *RSSFeedPlugin) findUploadedOPML(channelID string, userID string) (*OPML, error) {
+	posts, appErr := p.API.GetPostsForChannel(channelID, 0, opmlPostSearchLimit)
+	if appErr != nil {
+		return nil, appErr
+	}
+
+	for _, postID := range posts.Order {
+		post := posts.Posts[postID]
+		if post.UserId != userID {
+			continue
+		}
+
+		for _, fileID := range post.FileIds {
+			info, appErr := p.API.GetFileInfo(fileID)
+			if appErr != nil {
+				return nil, appErr
+			}
+
+			extension := strings.ToLower(info.Extension)
+			if extension != "opml" && extension != "xml" {
+				continue
+			}
+
+			data, appErr := p.API.GetFile(fileID)
+			if appErr != nil {
+				return nil, appErr
+			}
+
+			return OPMLParseString(string(data))
+		}
+	}
+
+	return nil, errors.New("no OPML file found, upload one to this channel or pass a url")
+}
+
+// buildOPML creates an OPML 2.0 document from the subscriptions of the channel
+func (p *RSSFeedPlugin) buildOPML(channelID string) (*OPML, error) {
+	subs, err := p.getSubscriptions(channelID)
+	if err != nil {
+		return nil, err
+	}
+
+	title := "Feeds"
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr == nil {
+		title = fmt.Sprintf("Feeds of %s", channel.DisplayName)
+	}
+
+	opml := &OPML{
+		Version: "2.0",
+		Head: OPMLHead{
+			Title:       title,
+			DateCreated: time.Now().UTC().Format(time.RFC1123),
+			Docs:        "http://opml.org/spec2.opml",
+		},
+	}
+
+	for _, sub := range subs.Subscriptions {
+		creator := ""
+		user, appErr := p.API.GetUser(sub.UserID)
+		if appErr == nil {
+			creator = user.Username
+		}
+
+		opml.Body.Outlines = append(opml.Body.Outlines, &OPMLOutline{
+			Text:    sub.Title,
+			Title:   sub.Title,
+			Type:    "rss",
+			XMLURL:  sub.URL,
+			HTMLURL: sub.Alternate,
+			Format:  sub.Format.String(),
+			Color:   sub.Color,
+			Creator: creator,
+		})
+	}
+
+	return opml, nil
+}
+
+// exportOPML uploads the channel's subscriptions as an opml file and posts it to the channel
+func (p *RSSFeedPlugin) exportOPML(channelID string) error {
+	opml, err := p.buildOPML(channelID)
+	if err != nil {
+		return err
+	}
+
+	body, err := opml.OPMLString()
+	if err != nil {
+		return err
+	}
+
+	filename := "feeds.opml"
+	channel, appErr := p.API.GetChannel(channelID)
+	if appErr == nil {
+		filename = fmt.Sprintf("feeds-%s.opml", channel.Name)
+	}
+
+	info, appErr := p.API.UploadFile([]byte(body), channelID, filename)
+	if appErr != nil {
+		return appErr
+	}
+
+	post := &model.Post{
+		UserId:    p.botUserID,
+		ChannelId: channelID,
+		Message:   fmt.Sprintf("OPML export of %d subscriptions", len(opml.Body.Outlines)),
+		FileIds:   []string{info.Id},
+	}
+
+	if _, appErr := p.API.CreatePost(post); appErr != nil {
+		return appErr
+	}
+	return nil
+}
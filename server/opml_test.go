@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+const opmlTestFeed = `<feed xmlns="http://www.w3.org/2005/Atom"><title>Releases</title><author><name>Release Team</name></author>
+	<link rel="alternate" href="https://example.com/"/>
+</feed>`
+
+func TestExportedOPMLImports(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	api.On("GetChannel", "source").Return(&model.Channel{Id: "source", DisplayName: "Town Square"}, nil)
+	api.On("GetUser", "owner").Return(&model.User{Id: "owner", Username: "alice"}, nil)
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+
+	var exported string
+	p := &RSSFeedPlugin{FeedHandler: FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		body := opmlTestFeed
+		if req.URL.Path == "/feeds.opml" {
+			body = exported
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}}
+	p.SetAPI(api)
+
+	require.NoError(t, p.addSubscription("source", &Subscription{
+		ID:        1,
+		Title:     "Releases",
+		URL:       "https://example.com/feed",
+		Alternate: "https://example.com/",
+		Format:    FeedFormatAtom,
+		Color:     "#123456",
+		UserID:    "owner",
+	}))
+
+	opml, err := p.buildOPML("source")
+	require.NoError(t, err)
+	assert.Equal(t, "Feeds of Town Square", opml.Head.Title)
+	exported, err = opml.OPMLString()
+	require.NoError(t, err)
+
+	parsed, err := OPMLParseString(exported)
+	require.NoError(t, err)
+	require.Len(t, parsed.Feeds(), 1)
+	assert.Equal(t, OPMLOutline{
+		Text:    "Releases",
+		Title:   "Releases",
+		Type:    "rss",
+		XMLURL:  "https://example.com/feed",
+		HTMLURL: "https://example.com/",
+		Format:  FeedFormatAtom.String(),
+		Color:   "#123456",
+		Creator: "alice",
+	}, *parsed.Feeds()[0])
+
+	p.importOPML(context.Background(), "https://example.com/feeds.opml", "target", "importer")
+
+	subs, err := p.getSubscriptions("target")
+	require.NoError(t, err)
+	require.Len(t, subs.Subscriptions, 1)
+	sub := subs.Subscriptions[0]
+	assert.Equal(t, "https://example.com/feed", sub.URL)
+	assert.Equal(t, FeedFormatAtom, sub.Format)
+	assert.Equal(t, "#123456", sub.Color)
+	// the importer owns the new subscription
+	assert.Equal(t, "importer", sub.UserID)
+}
+
+func TestHandleHTTPExport(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	api.On("HasPermissionToChannel", "member", "channel", model.PERMISSION_READ_CHANNEL).Return(true)
+	api.On("HasPermissionToChannel", "outsider", "channel", model.PERMISSION_READ_CHANNEL).Return(false)
+	api.On("GetChannel", "channel").Return(&model.Channel{Id: "channel", DisplayName: "Town Square"}, nil)
+	api.On("GetUser", mock.Anything).Return(nil, &model.AppError{Message: "not found"})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.addSubscription("channel", &Subscription{ID: 1, Title: "Releases", URL: "https://example.com/feed"}))
+
+	export := func(userID string, query string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/export"+query, nil)
+		if userID != "" {
+			r.Header.Set("Mattermost-User-Id", userID)
+		}
+		w := httptest.NewRecorder()
+		p.handleHTTPExport(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, export("", "?channel=channel").Code)
+	assert.Equal(t, http.StatusBadRequest, export("member", "").Code)
+	assert.Equal(t, http.StatusForbidden, export("outsider", "?channel=channel").Code)
+
+	w := export("member", "?channel=channel")
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "text/x-opml; charset=utf-8", w.Header().Get("Content-Type"))
+	opml, err := OPMLParseString(w.Body.String())
+	require.NoError(t, err)
+	require.Len(t, opml.Feeds(), 1)
+	assert.Equal(t, "https://example.com/feed", opml.Feeds()[0].XMLURL)
+}
+
+func TestImportOPMLSummary(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	posts := []*model.Post{}
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		posts = append(posts, post)
+		return post
+	}, nil)
+	api.On("LogError", mock.Anything).Return()
+
+	p := &RSSFeedPlugin{FeedHandler: FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		switch req.URL.Path {
+		case "/feeds.opml":
+			body := `<opml version="2.0"><body>
+				<outline text="New" xmlUrl="https://example.com/new"/>
+				<outline text="Known" xmlUrl="https://example.com/known"/>
+				<outline text="Broken" xmlUrl="https://example.com/broken"/>
+			</body></opml>`
+			return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+		case "/broken":
+			return &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found", Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(opmlTestFeed))}, nil
+	})}}
+	p.SetAPI(api)
+	require.NoError(t, p.addSubscription("channel", &Subscription{ID: 1, Title: "Known", URL: "https://example.com/known"}))
+
+	p.importOPML(context.Background(), "https://example.com/feeds.opml", "channel", "user")
+
+	require.Len(t, posts, 1)
+	attachments := posts[0].Attachments()
+	require.Len(t, attachments, 1)
+	assert.Equal(t, "Imported 1 of 3 feeds", attachments[0].Title)
+	assert.Equal(t, []*model.SlackAttachmentField{
+		{Title: "Subscribed (1)", Value: "Releases"},
+		{Title: "Already subscribed (1)", Value: "Known"},
+		{Title: "Failed (1)", Value: "https://example.com/broken: `https://example.com/broken responded with 404 Not Found`"},
+	}, attachments[0].Fields)
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"crypto/md5" //nolint comments
 	"encoding/hex"
 	"encoding/json"
@@ -8,10 +9,10 @@ import (
 	"hash/fnv"
 	"io/ioutil"
 	"net/http"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -34,8 +35,22 @@ type RSSFeedPlugin struct {
 	// setConfiguration for usage.
 	configuration *configuration
 
-	botUserID            string
-	processHeartBeatFlag bool
+	botUserID  string
+	nodeID     string // identifies this node when taking locks, see lock.go
+	storeReady int32  // 1 once the stored data is at schemaVersion, see checkStoreReady
+	recheck    int32  // 1 if the next heartbeat reads every channel, see recheckChannels
+	lockClock  clock  // the system clock if nil, see lock.go
+
+	// schedulerLock synchronizes access to the scheduler, see scheduler.go
+	schedulerLock sync.Mutex
+	scheduler     *scheduler
+
+	// fetchPoolLock synchronizes access to the fetch pool, see pool.go
+	fetchPoolLock sync.Mutex
+	fetchPool     *fetchPool
+
+	// notifications of rssCloud being processed, see handleHTTPRSSCloud
+	rssCloudPending sync.Map
 
 	FeedHandler
 }
@@ -47,9 +62,22 @@ func (p *RSSFeedPlugin) ServeHTTP(c *plugin.Context, w http.ResponseWriter, r *h
 		p.handleIcon(w, r)
 	case "/unsub":
 		p.handleHTTPUnsub(w, r)
+	case "/discover":
+		p.handleHTTPDiscover(w, r)
 	case "/fetch":
 		p.handleHTTPFetch(w, r)
+	case "/export":
+		p.handleHTTPExport(w, r)
 	default:
+		if strings.HasPrefix(path, "/websub/") {
+			p.handleHTTPWebSub(w, r)
+			return
+		}
+		if strings.HasPrefix(path, "/rsscloud/") {
+			p.handleHTTPRSSCloud(w, r)
+			return
+		}
+
 		w.Header().Set("Content-Type", "application/json")
 		http.NotFound(w, r)
 	}
@@ -88,9 +116,58 @@ func (p *RSSFeedPlugin) handleHTTPFetch(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	ctx, done, ok := p.beginWork(r.Context())
+	if !ok {
+		http.Error(w, errDeactivating.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	defer done()
+
+	// FIXME: failing feeds will fail silently
+	if err := p.processChannel(ctx, channelID[0], true); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 	fmt.Fprintf(w, "OK")
-	// FIXME: this will fail silently
-	p.processChannel(channelID[0])
+}
+
+func (p *RSSFeedPlugin) handleHTTPExport(w http.ResponseWriter, r *http.Request) {
+	// set by the server for requests with a valid session
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	channelID := r.URL.Query().Get("channel")
+	if channelID == "" {
+		http.Error(w, "channel is required", http.StatusBadRequest)
+		return
+	}
+
+	if !p.API.HasPermissionToChannel(userID, channelID, model.PERMISSION_READ_CHANNEL) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	opml, err := p.buildOPML(channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := opml.OPMLString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/x-opml; charset=utf-8")
+	w.Header().Set("Content-Disposition", "attachment; filename=\"feeds.opml\"")
+	_, writeErr := w.Write([]byte(body))
+	if writeErr != nil {
+		p.API.LogError(writeErr.Error())
+	}
 }
 
 func (p *RSSFeedPlugin) ensureIds(channelID string, subs *SubscriptionList) {
@@ -101,11 +178,20 @@ func (p *RSSFeedPlugin) ensureIds(channelID string, subs *SubscriptionList) {
 			s.ID = makeHash(s.URL)
 		}
 	}
-	if updated {
-		err := p.storeSubscriptions(channelID, subs)
-		if err != nil {
-			return
+	if !updated {
+		return
+	}
+
+	err := p.modifySubscriptions(channelID, func(stored *SubscriptionList) error {
+		for _, s := range stored.Subscriptions {
+			if s.ID == 0 {
+				s.ID = makeHash(s.URL)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		p.API.LogError(err.Error())
 	}
 }
 
@@ -231,66 +317,373 @@ func (p *RSSFeedPlugin) handleHTTPUnsub(w http.ResponseWriter, r *http.Request)
 	_, _ = w.Write(resp.ToJson())
 }
 
-func (p *RSSFeedPlugin) setupHeartBeat() {
-	heartbeatTime, err := p.getHeartbeatTime()
-	if err != nil {
-		p.API.LogError(err.Error())
+// the discovered feeds are kept in the action context so the menu can be rebuilt on select,
+// the page is kept to check the choice against what it advertises before subscribing
+func (p *RSSFeedPlugin) makeDiscoverAttachments(pageURL string, urls []string, titles []string, selected string) *model.SlackAttachment {
+	options := make([]*model.PostActionOptions, len(urls))
+	for i, u := range urls {
+		options[i] = &model.PostActionOptions{
+			Text:  titles[i],
+			Value: u,
+		}
 	}
 
-	for p.processHeartBeatFlag {
-		err := p.processHeartBeat()
-		if err != nil {
-			p.API.LogError(err.Error())
+	url := p.getURL() + "/discover"
+
+	return &model.SlackAttachment{
+		Actions: []*model.PostAction{{
+			Type: model.POST_ACTION_TYPE_SELECT,
+			Name: "Select Feed",
+			Integration: &model.PostActionIntegration{
+				URL: url,
+				Context: model.StringInterface{
+					"action": "select",
+					"page":   pageURL,
+					"urls":   urls,
+					"titles": titles,
+				},
+			},
+			Options:       options,
+			DefaultOption: selected,
+		}, {
+			Type: model.POST_ACTION_TYPE_BUTTON,
+			Name: "Subscribe",
+			Integration: &model.PostActionIntegration{
+				URL: url,
+				Context: model.StringInterface{
+					"action":          "post",
+					"page":            pageURL,
+					"urls":            urls,
+					"selected_option": selected,
+				},
+			},
+		}},
+	}
+}
+
+func (p *RSSFeedPlugin) handleHTTPDiscover(w http.ResponseWriter, r *http.Request) {
+	// set by the server for requests with a valid session
+	userID := r.Header.Get("Mattermost-User-Id")
+	if userID == "" {
+		http.Error(w, "not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	request := model.PostActionIntegrationRequestFromJson(r.Body)
+
+	if request == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// the rest of the request comes from the client, it may only subscribe its own user in channels they can read
+	if request.UserId != userID || !p.API.HasPermissionToChannel(userID, request.ChannelId, model.PERMISSION_READ_CHANNEL) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	action, actionOK := request.Context["action"].(string)
+	selected, selectedOK := request.Context["selected_option"].(string)
+	page, pageOK := request.Context["page"].(string)
+	urls := toStringSlice(request.Context["urls"])
+
+	if !(actionOK && selectedOK && pageOK) || (selected != "" && !containsString(urls, selected)) {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var attachment *model.SlackAttachment
+	switch action {
+	case "select":
+		titles := toStringSlice(request.Context["titles"])
+		if len(urls) != len(titles) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		attachment = p.makeDiscoverAttachments(page, urls, titles, selected)
+
+	case "post":
+		if selected == "" {
+			attachment = &model.SlackAttachment{
+				Title: "No changes were made",
+				Color: "#03fc73",
+			}
+		} else {
+			p.goWork(func(ctx context.Context) {
+				p.subscribeDiscovered(ctx, page, selected, request.ChannelId, userID)
+			})
+
+			attachment = &model.SlackAttachment{
+				Title: "Subscribing",
+				Text:  selected,
+				Color: "#03fc73",
+			}
+		}
+	default:
+		attachment = &model.SlackAttachment{
+			Title: "Error",
+			Text:  "invalid request",
+			Color: "#03fc73",
 		}
-		time.Sleep(time.Duration(heartbeatTime) * time.Minute)
 	}
+
+	post := &model.Post{
+		Id:        request.PostId,
+		UserId:    p.botUserID,
+		ChannelId: request.ChannelId,
+		Props: model.StringInterface{
+			"attachments": []*model.SlackAttachment{
+				attachment,
+			},
+		},
+	}
+
+	p.API.UpdateEphemeralPost(request.UserId, post)
+
+	resp := &model.PostActionIntegrationResponse{}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.ToJson())
 }
 
-func (p *RSSFeedPlugin) processHeartBeat() error {
+// processHeartBeat polls the due subscriptions of every channel if this node is the poller,
+// stops early when ctx is cancelled
+func (p *RSSFeedPlugin) processHeartBeat(ctx context.Context) error {
+	if err := p.checkStoreReady(); err != nil {
+		p.API.LogInfo("skipping heartbeat", "err", err.Error())
+		return nil
+	}
+	if !p.isPoller() {
+		p.API.LogDebug("skipping heartbeat, another node is polling")
+		return nil
+	}
+
 	p.API.LogDebug("processing heartbeat")
-	const keysPerPage = 50
 
-	for index := 0; true; index++ {
-		channelIDs, err := p.API.KVList(index, keysPerPage)
+	// a heartbeat can take longer than the lease, another node must not start polling meanwhile
+	ctx, stop := p.keepLock(ctx, pollerLockKey, p.nodeID, pollerLeaseTTL)
+	defer stop()
+
+	// every feed is fetched once per heartbeat, no matter how many channels subscribed to it
+	ctx = withFetchCycle(ctx)
+	cycle := fetchCycleFrom(ctx)
+
+	channelIDs, err := p.getChannelIDs()
+	if err != nil {
+		return err
+	}
+
+	// most channels have nothing due, only the subscriptions of the ones that are due are read,
+	// the subscriptions due anywhere are known before deciding which channels to poll
+	now := time.Now()
+	recheck := atomic.SwapInt32(&p.recheck, 0) == 1
+	lists := make(map[string]*SubscriptionList)
+	for _, channelID := range channelIDs {
+		next, err := p.getNextPoll(channelID)
+		if err != nil {
+			p.API.LogError(err.Error())
+			continue
+		}
+		if next > now.Unix() && !recheck {
+			continue
+		}
 
+		list, err := p.getSubscriptions(channelID)
 		if err != nil {
-			return err
+			p.API.LogError(err.Error())
+			continue
 		}
-		for _, channelID := range channelIDs {
-			p.processChannel(channelID)
+		lists[channelID] = list
+		cycle.markDue(list, now)
+	}
+	for _, list := range lists {
+		cycle.expect(list, now)
+	}
+
+	// channels are processed side by side, but no more of them than feeds can be fetched at once
+	settings := p.getPollSettings()
+	workers, _ := p.getFetchLimits()
+	slots := make(chan struct{}, workers)
+
+	var wg sync.WaitGroup
+	for _, channelID := range channelIDs {
+		list, ok := lists[channelID]
+		if !ok {
+			continue
+		}
+		if !list.needsPoll(settings, now, cycle) {
+			// read because it was rechecked, it is not due before its next fetch
+			p.storeNextPoll(channelID, list)
+			continue
 		}
 
-		if len(channelIDs) < keysPerPage {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
 			break
 		}
+
+		wg.Add(1)
+		go func(channelID string) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+
+			err := p.processChannel(ctx, channelID, false)
+			if err == errChannelBusy {
+				// a manual fetch is running, due subscriptions are picked up by the next heartbeat
+				return
+			}
+			if err != nil {
+				p.API.LogError(err.Error())
+			}
+		}(channelID)
 	}
+	wg.Wait()
 
 	return nil
 }
 
-func (p *RSSFeedPlugin) processChannel(channelID string) {
+// processChannel polls the subscriptions of the channel that are due, or all of them if force is set,
+// returns errChannelBusy if the channel is already being fetched
+func (p *RSSFeedPlugin) processChannel(ctx context.Context, channelID string, force bool) error {
+	return p.withChannelLock(ctx, channelID, 0, func(ctx context.Context) {
+		p.processChannelLocked(ctx, channelID, force)
+	})
+}
+
+func (p *RSSFeedPlugin) processChannelLocked(ctx context.Context, channelID string, force bool) {
 	list, err := p.getSubscriptions(channelID)
 	if err != nil {
 		p.API.LogError(err.Error())
 		return
 	}
+	// commands may change the subscriptions while they are fetched, only what fetching changed is stored
+	base := list.clone()
+
+	now := time.Now()
+	settings := p.getPollSettings()
+	cycle := fetchCycleFrom(ctx)
+	changed := false
 
 	var wg sync.WaitGroup
 	for i, sub := range list.Subscriptions {
+		if ctx.Err() != nil {
+			break
+		}
+
+		if !force && !sub.isDue(now) && !cycle.feedDue(sub) {
+			if sub.needsScheduling(settings, now) {
+				previous := sub.NextFetch
+				sub.scheduleNextFetch(now, settings)
+				changed = changed || sub.NextFetch != previous
+			}
+			if sub.cloudRenewalDue(now) {
+				// the registration expires long before quiet or paused feeds are polled
+				changed = true
+				wg.Add(1)
+				go func(sub *Subscription) {
+					defer wg.Done()
+					p.ensureRSSCloud(ctx, channelID, sub)
+				}(sub)
+			}
+			continue
+		}
+
+		changed = true
 		wg.Add(1)
 		go func(channelID string, sub *Subscription, i int) {
 			defer wg.Done()
-			p.processSubscription(channelID, sub)
+			p.processSubscription(ctx, channelID, sub)
 		}(channelID, sub, i)
 	}
 	wg.Wait()
 
-	err = p.storeSubscriptions(channelID, list)
+	if !changed {
+		return
+	}
+
+	err = p.storeFetchState(channelID, base, list)
 	if err != nil {
 		p.API.LogError(err.Error())
 	}
 }
 
+// getFailureThresholds returns after how many consecutive failed fetches the creator of a subscription
+// is notified and after how many the subscription is paused, 0 disables either
+func (p *RSSFeedPlugin) getFailureThresholds() (int, int) {
+	config := p.getConfiguration()
+	notify := 5
+	pause := 50
+
+	if len(config.FailureNotifyThreshold) > 0 {
+		if n, err := strconv.Atoi(config.FailureNotifyThreshold); err == nil {
+			notify = n
+		}
+	}
+	if len(config.FailurePauseThreshold) > 0 {
+		if n, err := strconv.Atoi(config.FailurePauseThreshold); err == nil {
+			pause = n
+		}
+	}
+
+	return notify, pause
+}
+
+// getPollSettings - the polling intervals from the configuration, the heartbeat is the default interval
+// and adaptive intervals are kept between 5 minutes and a day unless configured otherwise
+func (p *RSSFeedPlugin) getPollSettings() PollSettings {
+	config := p.getConfiguration()
+	heartbeatTime, _ := p.getHeartbeatTime()
+	if heartbeatTime < 1 {
+		heartbeatTime = 1
+	}
+
+	settings := PollSettings{
+		Default: time.Duration(heartbeatTime) * time.Minute,
+		Min:     5 * time.Minute,
+		Max:     24 * time.Hour,
+	}
+
+	if len(config.MinPollInterval) > 0 {
+		if n, err := strconv.Atoi(config.MinPollInterval); err == nil && n > 0 {
+			settings.Min = time.Duration(n) * time.Minute
+		}
+	}
+	if len(config.MaxPollInterval) > 0 {
+		if n, err := strconv.Atoi(config.MaxPollInterval); err == nil && n > 0 {
+			settings.Max = time.Duration(n) * time.Minute
+		}
+	}
+	if settings.Max < settings.Min {
+		settings.Max = settings.Min
+	}
+
+	return settings
+}
+
+// getFetchLimits returns how many feeds may be fetched at once, in total and from a single host
+func (p *RSSFeedPlugin) getFetchLimits() (int, int) {
+	config := p.getConfiguration()
+	size := defaultMaxConcurrentFetches
+	perHost := defaultMaxFetchesPerHost
+
+	if len(config.MaxConcurrentFetches) > 0 {
+		if n, err := strconv.Atoi(config.MaxConcurrentFetches); err == nil && n > 0 {
+			size = n
+		}
+	}
+	if len(config.MaxFetchesPerHost) > 0 {
+		if n, err := strconv.Atoi(config.MaxFetchesPerHost); err == nil && n > 0 {
+			perHost = n
+		}
+	}
+
+	return size, perHost
+}
+
 func (p *RSSFeedPlugin) getHeartbeatTime() (int, error) {
 	config := p.getConfiguration()
 	heartbeatTime := 15
@@ -311,42 +704,50 @@ creates bot post(s)
 
 DOES NOT SAVE ETAG TO DATABASE
 in order for content caching to work (preventing duplicate posts)
-storeSubscriptions must be called
+storeFetchState must be called
 */
-func (p *RSSFeedPlugin) processSubscription(channelID string, subscription *Subscription) {
+func (p *RSSFeedPlugin) processSubscription(ctx context.Context, channelID string, subscription *Subscription) {
 	config := p.getConfiguration()
+	defer fetchCycleFrom(ctx).done(subscription.URL)
 
-	attachments, err := p.processFeed(subscription, config)
-
-	if err != nil {
-		p.API.LogError(err.Error())
+	if subscription.Paused {
 		return
 	}
 
-	if attachments == nil {
+	subscription.scheduleNextFetch(time.Now(), p.getPollSettings())
+
+	p.ensureWebSub(ctx, channelID, subscription)
+	p.ensureRSSCloud(ctx, channelID, subscription)
+
+	oldURL := subscription.URL
+	items, validators, err := p.processFeed(withFetchPool(ctx, p.getFetchPool()), subscription, config)
+
+	if ctx.Err() != nil || err == errCacheFresh {
+		// cancelled by OnDeactivate or not fetched at all, not a failure of the feed
 		return
 	}
-
-	if config.SortMessages {
-		sort.Slice(attachments, func(i, j int) bool {
-			return attachments[i].Timestamp.(int64) < attachments[j].Timestamp.(int64)
-		})
+	if statusErr, ok := err.(*HTTPStatusError); ok && statusErr.StatusCode == http.StatusGone {
+		p.recordFeedGone(channelID, subscription, err)
+		return
 	}
-
-	// Send as separate messages or group as few messages as possible
-	var groupedAttachments [][]*model.SlackAttachment
-	if config.GroupMessages {
-		groupedAttachments, err = p.groupAttachments(attachments)
-		if err != nil {
-			p.API.LogError(err.Error())
-			return
-		}
-	} else {
-		groupedAttachments = p.padAttachments(attachments)
+	if err != nil {
+		p.API.LogError(err.Error())
+		p.recordFetchFailure(channelID, subscription, err)
+		return
 	}
+	validators.apply(subscription)
+	subscription.recordArrivals(items, time.Now())
+	p.recordFetchSuccess(channelID, subscription)
+
+	p.notifyMoved(channelID, subscription, oldURL)
 
-	for _, group := range groupedAttachments {
-		p.createBotPost("", channelID, "", group)
+	p.postItems(channelID, subscription, items, config)
+}
+
+// notifyMoved tells the channel that the subscription followed its feed to a new url
+func (p *RSSFeedPlugin) notifyMoved(channelID string, subscription *Subscription, oldURL string) {
+	if subscription.URL != oldURL {
+		p.createBotPost(fmt.Sprintf("The feed %s moved from %s", subscription.markdownLink(), oldURL), channelID, "", "", nil)
 	}
 }
 
@@ -420,11 +821,13 @@ func (p *RSSFeedPlugin) padAttachments(attachments []*model.SlackAttachment) [][
 	return result
 }
 
-// if userId is provided the post will be ephemeral
-func (p *RSSFeedPlugin) createBotPost(msg string, channelID string, userID string, attachments []*model.SlackAttachment) {
+// if userId is provided the post will be ephemeral, if rootID is provided the post is a reply to it,
+// returns the created post or nil if it was ephemeral or creating it failed
+func (p *RSSFeedPlugin) createBotPost(msg string, channelID string, userID string, rootID string, attachments []*model.SlackAttachment) *model.Post {
 	post := &model.Post{
 		UserId:    p.botUserID,
 		ChannelId: channelID,
+		RootId:    rootID,
 		Message:   msg,
 	}
 
@@ -434,12 +837,15 @@ func (p *RSSFeedPlugin) createBotPost(msg string, channelID string, userID strin
 
 	if userID != "" {
 		_ = p.API.SendEphemeralPost(userID, post)
-	} else {
-		_, err := p.API.CreatePost(post)
-		if err != nil {
-			p.API.LogError(err.Error())
-		}
+		return nil
 	}
+
+	created, err := p.API.CreatePost(post)
+	if err != nil {
+		p.API.LogError(err.Error())
+		return nil
+	}
+	return created
 }
 
 func getGravatarIcon(email string, defaultIcon string) string {
@@ -453,6 +859,32 @@ func getGravatarIcon(email string, defaultIcon string) string {
 	return fmt.Sprintf("https://www.gravatar.com/avatar/%s?d=%s&s40", hash, defaultIcon)
 }
 
+// containsString reports whether value is one of values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// toStringSlice converts a json decoded array back into strings
+func toStringSlice(value interface{}) []string {
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	result := make([]string, 0, len(values))
+	for _, v := range values {
+		if str, ok := v.(string); ok {
+			result = append(result, str)
+		}
+	}
+	return result
+}
+
 func hashColor(s string) string {
 	hash := fnv.New32()
 	_, err := hash.Write([]byte(s))
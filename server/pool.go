@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// default limits of the fetch pool, see MaxConcurrentFetches and MaxFetchesPerHost
+const (
+	defaultMaxConcurrentFetches = 10
+	defaultMaxFetchesPerHost    = 2
+)
+
+// fetchPool limits how many feeds are fetched at once, in total and from a single host,
+// so one large channel can't starve the others and no publisher gets too many requests at once
+type fetchPool struct {
+	slots   chan struct{}
+	perHost int
+
+	lock  sync.Mutex
+	hosts map[string]*hostSlots
+}
+
+// hostSlots - the fetches running against a host, removed from the pool once nobody uses them
+type hostSlots struct {
+	slots chan struct{}
+	users int
+}
+
+func newFetchPool(size int, perHost int) *fetchPool {
+	if size < 1 {
+		size = 1
+	}
+	if perHost < 1 {
+		perHost = 1
+	}
+
+	return &fetchPool{
+		slots:   make(chan struct{}, size),
+		perHost: perHost,
+		hosts:   map[string]*hostSlots{},
+	}
+}
+
+// acquire waits for a free slot for host, the returned func has to be called when the fetch is done
+func (pool *fetchPool) acquire(ctx context.Context, host string) (func(), error) {
+	host = strings.ToLower(host)
+	hostSlots := pool.useHost(host)
+
+	select {
+	case hostSlots.slots <- struct{}{}:
+	case <-ctx.Done():
+		pool.releaseHost(host)
+		return nil, ctx.Err()
+	}
+
+	select {
+	case pool.slots <- struct{}{}:
+	case <-ctx.Done():
+		<-hostSlots.slots
+		pool.releaseHost(host)
+		return nil, ctx.Err()
+	}
+
+	return func() {
+		<-pool.slots
+		<-hostSlots.slots
+		pool.releaseHost(host)
+	}, nil
+}
+
+func (pool *fetchPool) useHost(host string) *hostSlots {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	slots, ok := pool.hosts[host]
+	if !ok {
+		slots = &hostSlots{slots: make(chan struct{}, pool.perHost)}
+		pool.hosts[host] = slots
+	}
+	slots.users++
+	return slots
+}
+
+func (pool *fetchPool) releaseHost(host string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	slots := pool.hosts[host]
+	slots.users--
+	if slots.users == 0 {
+		delete(pool.hosts, host)
+	}
+}
+
+// fetchPoolKey - the context key of the pool fetches wait in
+type fetchPoolKey struct{}
+
+// withFetchPool makes every fetch with the returned context wait for a slot of the pool,
+// the slot is only held while the response is read so a fetch never waits while holding one
+func withFetchPool(ctx context.Context, pool *fetchPool) context.Context {
+	return context.WithValue(ctx, fetchPoolKey{}, pool)
+}
+
+// fetchPoolFrom returns the pool of the context, nil if fetches aren't limited
+func fetchPoolFrom(ctx context.Context) *fetchPool {
+	pool, _ := ctx.Value(fetchPoolKey{}).(*fetchPool)
+	return pool
+}
+
+// acquireFetchSlot waits for a slot of the pool of ctx for a request to host,
+// the returned func has to be called once the response was read
+func acquireFetchSlot(ctx context.Context, host string) (func(), error) {
+	pool := fetchPoolFrom(ctx)
+	if pool == nil {
+		return func() {}, nil
+	}
+	return pool.acquire(ctx, host)
+}
+
+// getFetchPool returns the pool for the configured limits, creating it on first use
+func (p *RSSFeedPlugin) getFetchPool() *fetchPool {
+	p.fetchPoolLock.Lock()
+	defer p.fetchPoolLock.Unlock()
+
+	if p.fetchPool == nil {
+		size, perHost := p.getFetchLimits()
+		p.fetchPool = newFetchPool(size, perHost)
+	}
+	return p.fetchPool
+}
+
+// resetFetchPool makes the next fetch use a pool with the current limits,
+// running fetches finish in the old pool
+func (p *RSSFeedPlugin) resetFetchPool() {
+	p.fetchPoolLock.Lock()
+	defer p.fetchPoolLock.Unlock()
+
+	p.fetchPool = nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchPoolLimitsHosts(t *testing.T) {
+	pool := newFetchPool(2, 1)
+
+	release, err := pool.acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	// another host still gets a slot
+	other, err := pool.acquire(context.Background(), "example.org")
+	require.NoError(t, err)
+	other()
+
+	// the host is at its limit
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = pool.acquire(ctx, "EXAMPLE.com")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	release, err = pool.acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+	release()
+
+	assert.Empty(t, pool.hosts)
+}
+
+func TestFetchResponseWaitsForPool(t *testing.T) {
+	requests := 0
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader("ok"))}, nil
+	})}
+
+	pool := newFetchPool(1, 1)
+	release, err := pool.acquire(context.Background(), "example.com")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(withFetchPool(context.Background(), pool), 10*time.Millisecond)
+	defer cancel()
+	_, err = handler.FetchFeedInfo(ctx, "https://example.com/feed")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, requests)
+
+	// the slot is given back once the response was read
+	release()
+	req, _ := http.NewRequest("GET", "https://example.com/feed", nil)
+	body, _, err := handler.fetchResponse(req.WithContext(withFetchPool(context.Background(), pool)))
+	require.NoError(t, err)
+	assert.Equal(t, "ok", body)
+	assert.Equal(t, 1, requests)
+	assert.Empty(t, pool.hosts)
+}
+
+func TestHubRequestsWaitForPool(t *testing.T) {
+	requests := 0
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		requests++
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})}
+
+	pool := newFetchPool(1, 1)
+	release, err := pool.acquire(context.Background(), "hub.example.com")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(withFetchPool(context.Background(), pool), 10*time.Millisecond)
+	defer cancel()
+	err = handler.RequestWebSub(ctx, "https://hub.example.com", WebSubModeSubscribe, "https://example.com/feed", "https://mattermost.example.com/websub/channel/1", "secret")
+	assert.Equal(t, context.DeadlineExceeded, err)
+	callback, _ := url.Parse("https://mattermost.example.com/plugins/rssfeed/rsscloud")
+	err = handler.RequestRSSCloud(ctx, "https://hub.example.com/rpc", "https://example.com/feed", callback)
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.Equal(t, 0, requests)
+
+	release()
+	err = handler.RequestWebSub(withFetchPool(context.Background(), pool), "https://hub.example.com", WebSubModeSubscribe, "https://example.com/feed", "https://mattermost.example.com/websub/channel/1", "secret")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, requests)
+	assert.Empty(t, pool.hosts)
+}
@@ -0,0 +1,143 @@
+/*
+structures have been defined using the RSS 1.0 specification
+in https://web.resource.org/rss/1.0/spec
+
+and the Dublin Core module
+in https://web.resource.org/rss/1.0/modules/dc/
+
+Unlike RSS 2.0, items are siblings of <channel> rather than children of it,
+and the whole document is wrapped in an <rdf:RDF> element.
+*/
+package main
+
+import (
+	"encoding/xml"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// W3C-DTF allows reduced precision dates, these are the common ones besides RFC 3339
+const (
+	rssV1DateOnlyLayout   = "2006-01-02"
+	rssV1DateMinuteLayout = "2006-01-02T15:04Z07:00"
+)
+
+/*
+RSSV1 - <rdf:RDF>
+The outermost level in every RSS 1.0 compliant document is the RDF element.
+
+<channel>, <image>, <item> and <textinput> are all direct children of it.
+*/
+type RSSV1 struct {
+	XMLName  xml.Name     `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# RDF"`
+	Channel  RSSV1Channel `xml:"http://purl.org/rss/1.0/ channel"`
+	ItemList []RSSV1Item  `xml:"http://purl.org/rss/1.0/ item"`
+}
+
+/*
+RSSV1Channel - <channel rdf:about="">
+The channel element contains metadata describing the channel itself,
+including a title, brief description, and URL link to the described resource.
+
+The Dublin Core elements are optional.
+*/
+type RSSV1Channel struct {
+	About       string `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Date        string `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Publisher   string `xml:"http://purl.org/dc/elements/1.1/ publisher"`
+	Language    string `xml:"http://purl.org/dc/elements/1.1/ language"`
+	Rights      string `xml:"http://purl.org/dc/elements/1.1/ rights"`
+}
+
+/*
+RSSV1Item - <item rdf:about="">
+While commonly a news headline, with RSS 1.0's modular extensibility, this can be just about anything.
+
+The rdf:about attribute must be unique and is usually the same as the link,
+which makes it a suitable identifier for the item.
+*/
+type RSSV1Item struct {
+	About       string   `xml:"http://www.w3.org/1999/02/22-rdf-syntax-ns# about,attr"`
+	Title       string   `xml:"title"`
+	Link        string   `xml:"link"`
+	Description string   `xml:"description"`
+	Content     string   `xml:"http://purl.org/rss/1.0/modules/content/ encoded"`
+	Date        string   `xml:"http://purl.org/dc/elements/1.1/ date"`
+	Creator     string   `xml:"http://purl.org/dc/elements/1.1/ creator"`
+	Subject     []string `xml:"http://purl.org/dc/elements/1.1/ subject"`
+}
+
+// RSSV1ParseString will be used to parse strings and will return the RSSV1 object
+func RSSV1ParseString(s string) (*RSSV1, error) {
+	rdf := RSSV1{}
+	if len(s) == 0 {
+		return &rdf, nil
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader(s))
+	decoder.CharsetReader = charset.NewReaderLabel
+	err := decoder.Decode(&rdf)
+	if err != nil {
+		return nil, err
+	}
+	return &rdf, nil
+}
+
+// ID - a string that uniquely identifies the item
+func (item *RSSV1Item) ID() string {
+	if item.About != "" {
+		return item.About
+	}
+	if item.Link != "" {
+		return item.Link
+	}
+	return item.Title + item.Date
+}
+
+// Categories - the dc:subject elements of the item
+func (item *RSSV1Item) Categories() []ItemCategory {
+	categories := []ItemCategory{}
+	for _, subject := range item.Subject {
+		if name := strings.TrimSpace(subject); name != "" {
+			categories = append(categories, ItemCategory{Name: name})
+		}
+	}
+	return categories
+}
+
+// Fingerprint - identifies the item in the seen item index
+func (item *RSSV1Item) Fingerprint() string {
+	return itemFingerprint(item.ID())
+}
+
+// Revision - changes whenever the title or link of the item is edited, like RSS 2.0
+// the description is left out and dc:date is when the item was published, not modified
+func (item *RSSV1Item) Revision() string {
+	return itemFingerprint(item.Title, item.Link)
+}
+
+// Fingerprints - the fingerprints of all items in the feed, in order
+func (rdf *RSSV1) Fingerprints() []string {
+	fingerprints := make([]string, len(rdf.ItemList))
+	for index := range rdf.ItemList {
+		fingerprints[index] = rdf.ItemList[index].Fingerprint()
+	}
+	return fingerprints
+}
+
+// RSSV1ParseTimestamp - turn a W3C-DTF dc:date into a unix timestamp, 0 if it can't be parsed
+func RSSV1ParseTimestamp(str string) int64 {
+	for _, layout := range []string{time.RFC3339, rssV1DateMinuteLayout, rssV1DateOnlyLayout} {
+		t, err := time.Parse(layout, strings.TrimSpace(str))
+		if err == nil {
+			return t.Unix()
+		}
+	}
+	return 0
+}
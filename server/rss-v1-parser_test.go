@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rssV1Sample = `<?xml version="1.0"?>
+<rdf:RDF
+	xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmlns:dc="http://purl.org/dc/elements/1.1/"
+	xmlns="http://purl.org/rss/1.0/">
+	<channel rdf:about="https://example.com/rss">
+		<title>Example</title>
+		<link>https://example.com/</link>
+		<description>Example RDF</description>
+		<dc:creator>Example Author</dc:creator>
+	</channel>
+	<item rdf:about="https://example.com/2">
+		<title>Second</title>
+		<link>https://example.com/2</link>
+		<dc:date>2020-04-21T10:00:00Z</dc:date>
+		<dc:creator>Item Author</dc:creator>
+	</item>
+	<item rdf:about="https://example.com/1">
+		<title>First</title>
+		<link>https://example.com/1</link>
+		<dc:date>2020-04-20</dc:date>
+	</item>
+</rdf:RDF>`
+
+func TestRSSV1ParseString(t *testing.T) {
+	feed, err := RSSV1ParseString(rssV1Sample)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Example", feed.Channel.Title)
+	assert.Equal(t, "Example Author", feed.Channel.Creator)
+	require.Len(t, feed.ItemList, 2)
+	assert.Equal(t, "Item Author", feed.ItemList[0].Creator)
+	assert.Equal(t, int64(1587463200), RSSV1ParseTimestamp(feed.ItemList[0].Date))
+	assert.Equal(t, int64(1587340800), RSSV1ParseTimestamp(feed.ItemList[1].Date))
+
+	assert.Equal(t, "https://example.com/2", feed.ItemList[0].ID())
+	assert.Equal(t, itemFingerprint("https://example.com/1"), feed.Fingerprints()[1])
+
+	_, err = RSSV2ParseString(rssV1Sample)
+	assert.Error(t, err)
+}
@@ -9,8 +9,10 @@ package main
 import (
 	"encoding/xml"
 	"strings"
+	"time"
 
 	"golang.org/x/net/html/charset"
+	"golang.org/x/tools/blog/atom"
 )
 
 /*RSSV2 - What is RSS?
@@ -72,25 +74,26 @@ textInput	Specifies a text input box that can be displayed with the channel. Mor
 skipHours	A hint for aggregators telling them which hours they can skip. More info here.
 skipDays	A hint for aggregators telling them which days they can skip. More info here.*/
 type Channel struct {
-	Title          string    `xml:"title"`
-	Link           string    `xml:"link"`
-	Description    string    `xml:"description"`
-	Language       string    `xml:"language"`
-	Copyright      string    `xml:"copyright"`
-	ManagingEditor string    `xml:"managingEditor"`
-	WebMaster      string    `xml:"webMaster"`
-	PubDate        string    `xml:"pubDate"`
-	LastBuildDate  string    `xml:"lastBuildDate"`
-	Category       string    `xml:"category"`
-	Generator      string    `xml:"generator"`
-	Docs           string    `xml:"docs"`
-	Image          Image     `xml:"image"`
-	Cloud          Cloud     `xml:"cloud"`
-	TTL            string    `xml:"ttl"`
-	ItemList       []Item    `xml:"item"`
-	TextInput      TextInput `xml:"textInput"`
-	SkipHours      []Hour    `xml:"skipHours"`
-	SkipDays       []Day     `xml:"skipDays"`
+	Title          string     `xml:"title"`
+	AtomLinks      []AtomLink `xml:"http://www.w3.org/2005/Atom link"` // must come before Link, see AtomLink
+	Link           string     `xml:"link"`
+	Description    string     `xml:"description"`
+	Language       string     `xml:"language"`
+	Copyright      string     `xml:"copyright"`
+	ManagingEditor string     `xml:"managingEditor"`
+	WebMaster      string     `xml:"webMaster"`
+	PubDate        string     `xml:"pubDate"`
+	LastBuildDate  string     `xml:"lastBuildDate"`
+	Category       []Category `xml:"category"`
+	Generator      string     `xml:"generator"`
+	Docs           string     `xml:"docs"`
+	Image          Image      `xml:"image"`
+	Cloud          Cloud      `xml:"cloud"`
+	TTL            string     `xml:"ttl"`
+	ItemList       []Item     `xml:"item"`
+	TextInput      TextInput  `xml:"textInput"`
+	SkipHours      []Hour     `xml:"skipHours"`
+	SkipDays       []Day      `xml:"skipDays"`
 }
 
 /*Image - <image> sub-element of <channel>
@@ -129,8 +132,21 @@ In this example, to request notification on the channel it appears in, you would
 
 A full explanation of this element and the rssCloud interface is here.*/
 type Cloud struct {
+	Domain            string `xml:"domain,attr"`
+	Port              string `xml:"port,attr"`
+	Path              string `xml:"path,attr"`
+	RegisterProcedure string `xml:"registerProcedure,attr"`
+	Protocol          string `xml:"protocol,attr"`
 }
 
+/*AtomLink - <atom:link> sub-element of <channel>
+Not part of RSS 2.0 itself, but commonly used to declare the canonical url of the feed (rel="self")
+and the WebSub hub it is published to (rel="hub").
+
+encoding/xml matches elements to the first field with the same local name,
+so this field has to be declared before the un-namespaced <link> field of Channel.*/
+type AtomLink = atom.Link
+
 /*Item - Elements of <item>
 A channel may contain any number of <item>s. An item may represent a "story" -- much like a story in a newspaper or magazine; if so its description is a synopsis of the story, and the link points to the full story. An item may also be complete in itself, if so, the description contains the text (entity-encoded HTML is allowed), and the link and title may be omitted. All elements of an item are optional, however at least one of title or description must be present.
 
@@ -147,16 +163,39 @@ pubDate	Indicates when the item was published. More.	Sun, 19 May 2002 15:21:36 G
 source	The RSS channel that the item came from. More.	<source url="http://www.quotationspage.com/data/qotd.rss">Quotes of the Day</source>
 */
 type Item struct {
-	Title       string    `xml:"title"`
-	Author      string    `xml:"author"`
-	Description string    `xml:"description"`
-	Link        string    `xml:"link"`
-	PubDate     string    `xml:"pubDate"`
-	GUID        string    `xml:"guid"`
-	Category    string    `xml:"category"`
-	Comments    string    `xml:"comments"`
-	Enclosure   Enclosure `xml:"enclosure"`
-	Source      string    `xml:"source"`
+	Title       string     `xml:"title"`
+	Author      string     `xml:"author"`
+	Description string     `xml:"description"`
+	Link        string     `xml:"link"`
+	PubDate     string     `xml:"pubDate"`
+	GUID        string     `xml:"guid"`
+	Category    []Category `xml:"category"`
+	Comments    string     `xml:"comments"`
+	Enclosure   Enclosure  `xml:"enclosure"`
+	Source      string     `xml:"source"`
+}
+
+/*Category - <category> sub-element of <channel> or <item>
+Includes the item in one or more categories, an item may have any number of them.
+
+<category> has one optional attribute, domain, a string that identifies a categorization taxonomy.
+
+<category domain="http://www.fool.com/cusips">MSFT</category>
+*/
+type Category struct {
+	Domain string `xml:"domain,attr"`
+	Value  string `xml:",chardata"`
+}
+
+// Categories - the categories of the item
+func (item *Item) Categories() []ItemCategory {
+	categories := []ItemCategory{}
+	for _, category := range item.Category {
+		if name := strings.TrimSpace(category.Value); name != "" {
+			categories = append(categories, ItemCategory{Name: name, Domain: category.Domain})
+		}
+	}
+	return categories
 }
 
 /*TextInput - <textInput> sub-element of <channel>
@@ -188,7 +227,7 @@ The hour beginning at midnight is hour zero.
 http://backend.userland.com/skipHoursDays#skiphours
 */
 type Hour struct {
-	Hour string `xml:"hour"`
+	Hour []string `xml:"hour"`
 }
 
 /*Day -
@@ -201,7 +240,7 @@ the channel during days listed in the skipDays element.
 http://backend.userland.com/skipHoursDays#skiphours
 */
 type Day struct {
-	Day string `xml:"day"`
+	Day []string `xml:"day"`
 }
 
 /*Enclosure -
@@ -238,26 +277,54 @@ func RSSV2ParseString(s string) (*RSSV2, error) {
 	return &rss, nil
 }
 
-// RSSV2CompareItemsBetweenOldAndNew - This function will used to compare 2 RSS xml item objects
-// and will return a list of items that are specifically in the newer feed but not in
-// the older feed
-func RSSV2CompareItemsBetweenOldAndNew(oldRSS *RSSV2, newRSS *RSSV2) []Item {
-	itemList := []Item{}
-
-	for _, item1 := range newRSS.Channel.ItemList {
-		exists := false
-		for _, item2 := range oldRSS.Channel.ItemList {
-			if len(item1.GUID) > 0 && item1.GUID == item2.GUID {
-				exists = true
-				break
-			} else if item1.PubDate == item2.PubDate && item1.Title == item2.Title {
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			itemList = append(itemList, item1)
+// ID - the guid of the item, or its link or title if there is none
+func (item *Item) ID() string {
+	if len(item.GUID) > 0 {
+		return item.GUID
+	}
+	if len(item.Link) > 0 {
+		return item.Link
+	}
+	return item.Title
+}
+
+// Fingerprint - identifies the item in the seen item index
+func (item *Item) Fingerprint() string {
+	return itemFingerprint(item.ID())
+}
+
+// Revision - changes whenever the title or link of the item is edited, descriptions
+// often embed counters or tracking parameters that change on every request
+func (item *Item) Revision() string {
+	return itemFingerprint(item.Title, item.Link)
+}
+
+// Fingerprints - the fingerprints of all items in the feed, in order
+func (rss *RSSV2) Fingerprints() []string {
+	fingerprints := make([]string, len(rss.Channel.ItemList))
+	for index := range rss.Channel.ItemList {
+		fingerprints[index] = rss.Channel.ItemList[index].Fingerprint()
+	}
+	return fingerprints
+}
+
+// RFC 822 allows single digit days and leaves out the day of the week, feeds make use of both
+var rssV2DateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"2 Jan 2006 15:04:05 MST",
+}
+
+// RSSV2ParseTimestamp - turn an RFC 822 pubDate into a unix timestamp, 0 if it can't be parsed
+func RSSV2ParseTimestamp(str string) int64 {
+	for _, layout := range rssV2DateLayouts {
+		t, err := time.Parse(layout, strings.TrimSpace(str))
+		if err == nil {
+			return t.Unix()
 		}
 	}
-	return itemList
+	return 0
 }
@@ -0,0 +1,216 @@
+/*
+rssCloud notifications
+http://home.rssboard.org/rsscloud-interface
+
+Only the http-post protocol is supported. Registrations expire after 25 hours,
+so the heartbeat renews them every 24 hours, however rarely the feed is polled and even while it is paused.
+
+Every subscription registers its own callback with a random token in the path,
+notifications are checked against it so nobody else can make the plugin fetch feeds,
+and notifications arriving right after a fetch or while one is running are ignored.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RSSCloudProtocolHTTPPost is the only <cloud protocol=""> the plugin can register with
+const RSSCloudProtocolHTTPPost = "http-post"
+
+const (
+	// renew registrations this often, the spec expires them after 25 hours
+	rssCloudRenewAfter = 24 * 60 * 60
+	// notifications this soon after a successful fetch are ignored
+	rssCloudMinNotifyInterval = 60
+)
+
+// RSSCloudNotifyResult - response of the cloud to a registration request
+type RSSCloudNotifyResult struct {
+	XMLName xml.Name `xml:"notifyResult"`
+	Success bool     `xml:"success,attr"`
+	Message string   `xml:"msg,attr"`
+}
+
+// RegisterURL - the http-post registration endpoint, empty if the cloud uses another protocol
+func (c *Cloud) RegisterURL() string {
+	if c.Domain == "" || !strings.EqualFold(c.Protocol, RSSCloudProtocolHTTPPost) {
+		return ""
+	}
+
+	port := c.Port
+	if port == "" {
+		port = "80"
+	}
+
+	scheme := "http"
+	if port == "443" {
+		scheme = "https"
+	}
+
+	path := c.Path
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	return fmt.Sprintf("%s://%s:%s%s", scheme, c.Domain, port, path)
+}
+
+// rssCloudCallbackURL - where the cloud notifies the subscription, the token in the path
+// keeps others from triggering fetches
+func (p *RSSFeedPlugin) rssCloudCallbackURL(channelID string, sub *Subscription) (*url.URL, error) {
+	return url.Parse(fmt.Sprintf("%s/rsscloud/%s/%d/%s", p.getURL(), channelID, sub.ID, sub.CloudToken))
+}
+
+// newRSSCloudToken returns a random token for the callback path of a registration
+func newRSSCloudToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *RSSFeedPlugin) registerRSSCloud(ctx context.Context, channelID string, sub *Subscription) {
+	callback, err := p.rssCloudCallbackURL(channelID, sub)
+	if err != nil {
+		p.API.LogError(err.Error())
+		return
+	}
+
+	err = p.RequestRSSCloud(withFetchPool(ctx, p.getFetchPool()), sub.Cloud, sub.URL, callback)
+	if err != nil {
+		p.API.LogError("rssCloud registration failed", "url", sub.URL, "err", err.Error())
+	}
+}
+
+// cloudRenewal - unix time at which the registration with the cloud has to be renewed,
+// registrations of older versions without a token right away. 0 without a cloud
+func (s *Subscription) cloudRenewal() int64 {
+	if s.Cloud == "" {
+		return 0
+	}
+	if s.CloudToken == "" {
+		return s.CloudRegistered
+	}
+	return s.CloudRegistered + rssCloudRenewAfter
+}
+
+// cloudRenewalDue is true if the registration with the cloud has to be renewed at now
+func (s *Subscription) cloudRenewalDue(now time.Time) bool {
+	return s.Cloud != "" && s.cloudRenewal() <= now.Unix()
+}
+
+// ensureRSSCloud renews the registration with the cloud once it is due.
+// The caller is responsible for storing the subscription
+func (p *RSSFeedPlugin) ensureRSSCloud(ctx context.Context, channelID string, sub *Subscription) {
+	now := time.Now()
+	if !sub.cloudRenewalDue(now) {
+		return
+	}
+
+	sub.CloudRegistered = now.Unix()
+	if sub.CloudToken == "" {
+		token, err := newRSSCloudToken()
+		if err != nil {
+			p.API.LogError("Failed to create rssCloud token", "err", err.Error())
+			return
+		}
+		sub.CloudToken = token
+	}
+	p.registerRSSCloud(ctx, channelID, sub)
+}
+
+// handles /rsscloud/{channelID}/{subscriptionID}/{token},
+// GET is the challenge sent when registering, POST is the update notification
+func (p *RSSFeedPlugin) handleHTTPRSSCloud(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/rsscloud/"), "/")
+	if len(parts) != 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	channelID := parts[0]
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subs, err := p.getSubscriptions(channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sub, _ := subs.findID(uint32(id))
+	if sub == nil || sub.Cloud == "" || sub.CloudToken == "" ||
+		subtle.ConstantTimeCompare([]byte(sub.CloudToken), []byte(parts[2])) != 1 ||
+		normalizeFeedURL(sub.URL) != normalizeFeedURL(r.Form.Get("url")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		_, writeErr := w.Write([]byte(r.Form.Get("challenge")))
+		if writeErr != nil {
+			p.API.LogError(writeErr.Error())
+		}
+	case http.MethodPost:
+		w.WriteHeader(http.StatusOK)
+		if time.Now().Unix()-sub.LastSuccess < rssCloudMinNotifyInterval {
+			// just fetched, clouds may notify several times for one change
+			return
+		}
+
+		// notifications arriving while the subscription is fetched are covered by that fetch
+		key := fmt.Sprintf("%s/%d", channelID, id)
+		if _, pending := p.rssCloudPending.LoadOrStore(key, true); pending {
+			return
+		}
+		started := p.goWork(func(ctx context.Context) {
+			defer p.rssCloudPending.Delete(key)
+			p.processNotifiedSubscription(ctx, channelID, uint32(id))
+		})
+		if !started {
+			p.rssCloudPending.Delete(key)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// processNotifiedSubscription fetches the subscription right away after the cloud notified a change
+func (p *RSSFeedPlugin) processNotifiedSubscription(ctx context.Context, channelID string, id uint32) {
+	var err error
+	lockErr := p.withChannelLock(ctx, channelID, channelLockWait, func(ctx context.Context) {
+		err = p.fetchSubscription(channelID, id, func(sub *Subscription) error {
+			// the feed just changed, whatever the cache headers said
+			sub.CacheUntil = 0
+			p.processSubscription(ctx, channelID, sub)
+			return nil
+		})
+	})
+	if lockErr != nil {
+		err = lockErr
+	}
+	if err != nil {
+		p.API.LogError(err.Error())
+	}
+}
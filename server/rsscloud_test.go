@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCloudRegisterURL(t *testing.T) {
+	feed, err := RSSV2ParseString(`<rss version="2.0"><channel>
+		<title>Example</title>
+		<cloud domain="rpc.example.com" port="5337" path="/rsscloud/pleaseNotify" registerProcedure="" protocol="http-post"/>
+	</channel></rss>`)
+	require.NoError(t, err)
+	assert.Equal(t, "http://rpc.example.com:5337/rsscloud/pleaseNotify", feed.Channel.Cloud.RegisterURL())
+
+	xmlRPC := Cloud{Domain: "rpc.example.com", Port: "80", Path: "/RPC2", RegisterProcedure: "pingMe", Protocol: "xml-rpc"}
+	assert.Equal(t, "", xmlRPC.RegisterURL())
+
+	secure := Cloud{Domain: "rpc.example.com", Port: "443", Path: "notify", Protocol: "HTTP-POST"}
+	assert.Equal(t, "https://rpc.example.com:443/notify", secure.RegisterURL())
+}
+
+func TestHandleHTTPRSSCloud(t *testing.T) {
+	channelID := model.NewId()
+	list := &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://example.com/feed", ID: 1, Cloud: "http://rpc.example.com:5337/notify", CloudToken: "token"},
+		{URL: "https://example.com/fresh", ID: 2, Cloud: "http://rpc.example.com:5337/notify", CloudToken: "token", LastSuccess: time.Now().Unix()},
+	}}
+	value, err := json.Marshal(list)
+	require.NoError(t, err)
+	api, _ := newKVTestAPI(map[string][]byte{
+		schemaVersionKey:           []byte(strconv.Itoa(schemaVersion)),
+		subscriptionKey(channelID): value,
+	})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	notify := func(method string, path string, feedURL string) int {
+		form := url.Values{"url": {feedURL}, "challenge": {"abc"}}
+		r := httptest.NewRequest(method, path+"?"+form.Encode(), nil)
+		w := httptest.NewRecorder()
+		p.ServeHTTP(nil, w, r)
+		return w.Code
+	}
+	path := "/rsscloud/" + channelID + "/1/token"
+
+	// only the cloud knows the token
+	assert.Equal(t, http.StatusNotFound, notify(http.MethodPost, "/rsscloud/"+channelID+"/1/guess", "https://example.com/feed"))
+	assert.Equal(t, http.StatusNotFound, notify(http.MethodPost, "/rsscloud", "https://example.com/feed"))
+	assert.Equal(t, http.StatusNotFound, notify(http.MethodPost, path, "https://example.com/other"))
+	assert.Equal(t, http.StatusOK, notify(http.MethodGet, path, "https://example.com/feed"))
+
+	// just fetched
+	assert.Equal(t, http.StatusOK, notify(http.MethodPost, "/rsscloud/"+channelID+"/2/token", "https://example.com/fresh"))
+	api.AssertNotCalled(t, "LogWarn", "Not starting a fetch", "err", errDeactivating.Error())
+
+	// a fetch is already pending
+	p.rssCloudPending.Store(channelID+"/1", true)
+	assert.Equal(t, http.StatusOK, notify(http.MethodPost, path, "https://example.com/feed"))
+	api.AssertNotCalled(t, "LogWarn", "Not starting a fetch", "err", errDeactivating.Error())
+
+	// fetched in the background, which isn't running here
+	p.rssCloudPending.Delete(channelID + "/1")
+	assert.Equal(t, http.StatusOK, notify(http.MethodPost, path, "https://example.com/feed"))
+	api.AssertCalled(t, "LogWarn", "Not starting a fetch", "err", errDeactivating.Error())
+	_, pending := p.rssCloudPending.Load(channelID + "/1")
+	assert.False(t, pending)
+}
+
+func TestCloudRenewal(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+	settings := PollSettings{Default: 15 * time.Minute}
+
+	// paused and polled weekly, the registration is still renewed daily
+	sub := &Subscription{
+		URL:             "https://example.com/feed",
+		Paused:          true,
+		NextFetch:       now.Add(7 * 24 * time.Hour).Unix(),
+		Interval:        7 * 24 * 60,
+		Cloud:           "http://rpc.example.com/notify",
+		CloudToken:      "token",
+		CloudRegistered: now.Add(-time.Hour).Unix(),
+	}
+	list := &SubscriptionList{Subscriptions: []*Subscription{sub}}
+	assert.False(t, sub.cloudRenewalDue(now))
+	assert.False(t, list.needsPoll(settings, now, nil))
+	assert.Equal(t, now.Add(channelRecheckInterval).Unix(), list.nextPoll(now))
+
+	sub.CloudRegistered = now.Add(-24*time.Hour + 10*time.Minute).Unix()
+	assert.Equal(t, now.Add(10*time.Minute).Unix(), list.nextPoll(now))
+	assert.True(t, sub.cloudRenewalDue(now.Add(10*time.Minute)))
+	assert.True(t, list.needsPoll(settings, now.Add(10*time.Minute), nil))
+
+	// registered by an older version without a token
+	sub.CloudToken = ""
+	assert.True(t, sub.cloudRenewalDue(now))
+}
+
+func TestHeartbeatRenewsCloudOfPausedSubscriptions(t *testing.T) {
+	channelID := model.NewId()
+	registered := time.Now().Add(-25 * time.Hour).Unix()
+	list := &SubscriptionList{Subscriptions: []*Subscription{{
+		URL:             "https://example.com/feed",
+		ID:              1,
+		Paused:          true,
+		NextFetch:       time.Now().Add(time.Hour).Unix(),
+		Cloud:           "http://rpc.example.com/notify",
+		CloudToken:      "token",
+		CloudRegistered: registered,
+	}}}
+	value, err := json.Marshal(list)
+	require.NoError(t, err)
+	api, _ := newKVTestAPI(map[string][]byte{
+		schemaVersionKey:           []byte(strconv.Itoa(schemaVersion)),
+		subscriptionKey(channelID): value,
+	})
+	siteURL := "https://mattermost.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+
+	var requested *http.Request
+	p := &RSSFeedPlugin{FeedHandler: FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req
+		body := `<notifyResult success="true" msg="Registered"/>`
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}, nil
+	})}}
+	p.SetAPI(api)
+
+	require.NoError(t, p.processChannel(context.Background(), channelID, false))
+	require.NotNil(t, requested)
+	assert.Equal(t, "https://example.com/feed", requested.FormValue("url1"))
+	assert.Equal(t, "/plugins/rssfeed/rsscloud/"+channelID+"/1/token", requested.FormValue("path"))
+
+	subs, err := p.getSubscriptions(channelID)
+	require.NoError(t, err)
+	assert.True(t, subs.Subscriptions[0].CloudRegistered > registered)
+}
@@ -0,0 +1,250 @@
+/*
+Polling schedule of subscriptions
+
+Every subscription has its own next fetch time. Subscriptions are polled every Heartbeat
+minutes unless the user set another interval, or the feed asks to be cached longer with <ttl>.
+Hours and days listed in <skipHours> and <skipDays> are skipped.
+http://backend.userland.com/skipHoursDays
+
+Once a few items have arrived the interval adapts to how often the feed publishes,
+within the configured minimum and maximum: quiet feeds are polled less, busy ones more.
+
+Each subscription polls at a fixed offset into its interval derived from its feed url,
+so the feeds of a channel are spread out instead of all being fetched at once,
+while channels subscribed to the same feed poll it together and share the fetch, see cycle.go.
+*/
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// schedulerTick - how often the scheduler looks for due subscriptions, the shortest possible interval
+const schedulerTick = time.Minute
+
+// channelRecheckInterval - the heartbeat reads every channel at least this often, even if nothing is due,
+// so subscriptions are rescheduled after the settings changed and a next poll stored out of order is corrected
+const channelRecheckInterval = time.Hour
+
+// where the interval of a subscription comes from
+const (
+	IntervalSourceDefault  = "default"
+	IntervalSourceOverride = "override"
+	IntervalSourceTTL      = "ttl"
+	IntervalSourceAdaptive = "adaptive"
+)
+
+// number of item arrivals remembered per subscription for the adaptive interval
+const maxArrivals = 20
+
+// PollSettings - the configured polling intervals
+type PollSettings struct {
+	Default time.Duration // interval until enough items arrived to adapt
+	Min     time.Duration // lower bound of adaptive intervals
+	Max     time.Duration // upper bound of adaptive intervals
+}
+
+// applyScheduleHints stores the <ttl>, <skipHours> and <skipDays> of the channel on the subscription
+func applyScheduleHints(sub *Subscription, channel *Channel) {
+	sub.TTL = 0
+	if ttl, err := strconv.Atoi(strings.TrimSpace(channel.TTL)); err == nil && ttl > 0 {
+		sub.TTL = ttl
+	}
+
+	sub.SkipHours = nil
+	for _, skip := range channel.SkipHours {
+		for _, hour := range skip.Hour {
+			if h, err := strconv.Atoi(strings.TrimSpace(hour)); err == nil && h >= 0 && h < 24 {
+				sub.SkipHours = append(sub.SkipHours, h)
+			}
+		}
+	}
+
+	sub.SkipDays = nil
+	for _, skip := range channel.SkipDays {
+		for _, day := range skip.Day {
+			if d, ok := parseWeekday(day); ok {
+				sub.SkipDays = append(sub.SkipDays, d)
+			}
+		}
+	}
+}
+
+func parseWeekday(day string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		if strings.EqualFold(strings.TrimSpace(day), d.String()) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// pollInterval - time between polls of the subscription and where it comes from,
+// an interval set by the user takes precedence over the adaptive interval and the feed's ttl
+func (s *Subscription) pollInterval(settings PollSettings, now time.Time) (time.Duration, string) {
+	if s.Interval > 0 {
+		return time.Duration(s.Interval) * time.Minute, IntervalSourceOverride
+	}
+
+	interval, source := settings.Default, IntervalSourceDefault
+	if adaptive, ok := s.adaptiveInterval(now); ok {
+		interval, source = adaptive, IntervalSourceAdaptive
+		if interval < settings.Min {
+			interval = settings.Min
+		}
+		if settings.Max > 0 && interval > settings.Max {
+			interval = settings.Max
+		}
+	}
+
+	ttl := time.Duration(s.TTL) * time.Minute
+	if ttl > interval {
+		return ttl, IntervalSourceTTL
+	}
+	return interval, source
+}
+
+// adaptiveInterval - half the average time between the remembered item arrivals,
+// counting the time since the last one so feeds that went quiet back off
+func (s *Subscription) adaptiveInterval(now time.Time) (time.Duration, bool) {
+	if len(s.Arrivals) < 2 {
+		return 0, false
+	}
+
+	span := now.Unix() - s.Arrivals[0]
+	if span <= 0 {
+		return 0, false
+	}
+	return time.Duration(span/int64(len(s.Arrivals))/2) * time.Second, true
+}
+
+// recordArrivals remembers when the new items arrived, items without a date or dated in the future
+// count as arriving now, except on the first fetch where they are the backlog of the feed
+func (s *Subscription) recordArrivals(items []*FeedItem, now time.Time) {
+	for _, item := range items {
+		if item.Updated {
+			continue
+		}
+
+		timestamp, _ := item.Attachment.Timestamp.(int64)
+		if timestamp > 0 && timestamp < now.Unix() {
+			s.Arrivals = append(s.Arrivals, timestamp)
+		} else if s.LastSuccess != 0 {
+			s.Arrivals = append(s.Arrivals, now.Unix())
+		}
+	}
+
+	sort.Slice(s.Arrivals, func(i, j int) bool {
+		return s.Arrivals[i] < s.Arrivals[j]
+	})
+	if len(s.Arrivals) > maxArrivals {
+		s.Arrivals = s.Arrivals[len(s.Arrivals)-maxArrivals:]
+	}
+}
+
+// skipped is true if the feed asked not to be polled at t
+func (s *Subscription) skipped(t time.Time) bool {
+	t = t.UTC()
+	for _, hour := range s.SkipHours {
+		if t.Hour() == hour {
+			return true
+		}
+	}
+	for _, day := range s.SkipDays {
+		if t.Weekday() == day {
+			return true
+		}
+	}
+	return false
+}
+
+// isDue is true if the subscription should be polled at now, paused subscriptions are never due
+// so they aren't rescheduled, and stored again, every heartbeat
+func (s *Subscription) isDue(now time.Time) bool {
+	return !s.Paused && s.NextFetch != 0 && s.NextFetch <= now.Unix()
+}
+
+// needsScheduling is true for new subscriptions and ones from older versions, which start at their slot,
+// and ones scheduled further out than their interval allows, which are moved up after the settings changed
+func (s *Subscription) needsScheduling(settings PollSettings, now time.Time) bool {
+	if s.NextFetch == 0 {
+		return true
+	}
+	interval, _ := s.pollInterval(settings, now)
+	return s.NextFetch > now.Add(interval).Unix()
+}
+
+// nextPoll - unix time at which the heartbeat has something to do for the subscriptions next,
+// 0 if a subscription hasn't been scheduled yet
+func (s *SubscriptionList) nextPoll(now time.Time) int64 {
+	next := now.Add(channelRecheckInterval).Unix()
+	for _, sub := range s.Subscriptions {
+		if !sub.Paused && sub.NextFetch < next {
+			next = sub.NextFetch
+		}
+		if sub.Cloud != "" && sub.cloudRenewal() < next {
+			next = sub.cloudRenewal()
+		}
+	}
+	return next
+}
+
+// needsPoll is true if the heartbeat has something to do for one of the subscriptions,
+// cycle is nil outside a heartbeat
+func (s *SubscriptionList) needsPoll(settings PollSettings, now time.Time, cycle *fetchCycle) bool {
+	for _, sub := range s.Subscriptions {
+		if sub.isDue(now) || cycle.feedDue(sub) || sub.needsScheduling(settings, now) || sub.cloudRenewalDue(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// scheduleNextFetch sets the next fetch to the first slot of the subscription after now
+// that isn't in a skipped hour or day
+func (s *Subscription) scheduleNextFetch(now time.Time, settings PollSettings) {
+	interval, _ := s.pollInterval(settings, now)
+	seconds := int64(interval / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	offset := s.phaseOffset(seconds)
+	next := now.Unix() - (now.Unix()-offset)%seconds + seconds
+
+	// move on to the next hour that isn't skipped, keeping the offset within the hour,
+	// a feed skipping every hour would never be polled so give up after a week
+	for limit := next + 7*24*60*60; s.skipped(time.Unix(next, 0)) && next < limit; {
+		next = time.Unix(next, 0).Truncate(time.Hour).Add(time.Hour).Unix() + offset%(60*60)
+	}
+
+	s.NextFetch = next
+}
+
+// phaseOffset - seconds into every interval at which the subscription polls,
+// the same for every subscription to a feed so the ones with the same interval come due together
+func (s *Subscription) phaseOffset(seconds int64) int64 {
+	hash := fnv.New32a()
+	hash.Write([]byte(normalizeFeedURL(s.URL)))
+	return int64(hash.Sum32()) % seconds
+}
+
+// scheduleText describes the interval and the next fetch of the subscription
+func (s *Subscription) scheduleText(settings PollSettings, now time.Time) string {
+	interval, source := s.pollInterval(settings, now)
+	text := fmt.Sprintf("every %d minutes (%s)", int64(interval/time.Minute), source)
+
+	if len(s.Arrivals) > 0 {
+		text += fmt.Sprintf(", %d items since %s", len(s.Arrivals), time.Unix(s.Arrivals[0], 0).UTC().Format("Jan 2"))
+	}
+	if s.NextFetch != 0 {
+		text += ", next " + time.Unix(s.NextFetch, 0).UTC().Format("Jan 2 15:04 MST")
+	}
+	return text
+}
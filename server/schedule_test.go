@@ -0,0 +1,121 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyScheduleHints(t *testing.T) {
+	feed, err := RSSV2ParseString(`<rss version="2.0"><channel>
+		<ttl>60</ttl>
+		<skipHours><hour>0</hour><hour>1</hour></skipHours>
+		<skipDays><day>Saturday</day><day>Sunday</day></skipDays>
+	</channel></rss>`)
+	require.NoError(t, err)
+
+	sub := &Subscription{}
+	applyScheduleHints(sub, &feed.Channel)
+
+	assert.Equal(t, 60, sub.TTL)
+	assert.Equal(t, []int{0, 1}, sub.SkipHours)
+	assert.Equal(t, []time.Weekday{time.Saturday, time.Sunday}, sub.SkipDays)
+
+	interval, source := sub.pollInterval(PollSettings{Default: 15 * time.Minute}, time.Now())
+	assert.Equal(t, time.Hour, interval)
+	assert.Equal(t, IntervalSourceTTL, source)
+
+	sub.Interval = 2
+	interval, source = sub.pollInterval(PollSettings{Default: 15 * time.Minute}, time.Now())
+	assert.Equal(t, 2*time.Minute, interval)
+	assert.Equal(t, IntervalSourceOverride, source)
+}
+
+func TestScheduleNextFetch(t *testing.T) {
+	// a Friday
+	now := time.Date(2020, 4, 24, 22, 50, 0, 0, time.UTC)
+
+	sub := &Subscription{ID: 1, URL: "https://example.com/feed"}
+	offset := sub.phaseOffset(15 * 60)
+	sub.scheduleNextFetch(now, PollSettings{Default: 15 * time.Minute})
+	assert.True(t, sub.NextFetch > now.Unix())
+	assert.True(t, sub.NextFetch <= now.Add(15*time.Minute).Unix())
+	assert.Equal(t, offset, sub.NextFetch%(15*60))
+	assert.False(t, sub.isDue(now))
+	assert.True(t, sub.isDue(time.Unix(sub.NextFetch, 0)))
+	sub.Paused = true
+	assert.False(t, sub.isDue(time.Unix(sub.NextFetch, 0)))
+	sub.Paused = false
+
+	// the same feed in another channel polls at the same time
+	other := &Subscription{ID: 7, URL: "https://EXAMPLE.com:443/feed"}
+	other.scheduleNextFetch(now, PollSettings{Default: 15 * time.Minute})
+	assert.Equal(t, sub.NextFetch, other.NextFetch)
+
+	// the weekend is skipped
+	sub.SkipDays = []time.Weekday{time.Saturday, time.Sunday}
+	sub.scheduleNextFetch(now.Add(24*time.Hour), PollSettings{Default: 15 * time.Minute})
+	assert.Equal(t, time.Date(2020, 4, 27, 0, 0, 0, 0, time.UTC).Unix()+offset%(60*60), sub.NextFetch)
+}
+
+func TestNeedsPoll(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+	settings := PollSettings{Default: 15 * time.Minute}
+
+	scheduled := &Subscription{NextFetch: now.Add(10 * time.Minute).Unix()}
+	paused := &Subscription{NextFetch: now.Add(-time.Hour).Unix(), Paused: true}
+	list := &SubscriptionList{Subscriptions: []*Subscription{scheduled, paused}}
+	assert.False(t, list.needsPoll(settings, now, nil))
+
+	// due
+	assert.True(t, list.needsPoll(settings, now.Add(10*time.Minute), nil))
+
+	// the interval was shortened since it was scheduled
+	assert.True(t, list.needsPoll(PollSettings{Default: 5 * time.Minute}, now, nil))
+
+	list.Subscriptions = append(list.Subscriptions, &Subscription{})
+	assert.True(t, list.needsPoll(settings, now, nil))
+}
+
+func TestNextPoll(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+
+	// nothing due, read again to recheck
+	list := &SubscriptionList{}
+	assert.Equal(t, now.Add(channelRecheckInterval).Unix(), list.nextPoll(now))
+
+	scheduled := &Subscription{NextFetch: now.Add(10 * time.Minute).Unix()}
+	paused := &Subscription{NextFetch: now.Add(-time.Hour).Unix(), Paused: true}
+	list.Subscriptions = []*Subscription{scheduled, paused}
+	assert.Equal(t, scheduled.NextFetch, list.nextPoll(now))
+
+	list.Subscriptions = append(list.Subscriptions, &Subscription{})
+	assert.Equal(t, int64(0), list.nextPoll(now))
+}
+
+func TestAdaptiveInterval(t *testing.T) {
+	now := time.Date(2020, 4, 24, 12, 0, 0, 0, time.UTC)
+	settings := PollSettings{Default: 15 * time.Minute, Min: 5 * time.Minute, Max: 24 * time.Hour}
+
+	sub := &Subscription{LastSuccess: 1}
+	interval, source := sub.pollInterval(settings, now)
+	assert.Equal(t, 15*time.Minute, interval)
+	assert.Equal(t, IntervalSourceDefault, source)
+
+	// an item every hour for the last 4 hours
+	items := []*FeedItem{}
+	for hour := 1; hour <= 4; hour++ {
+		items = append(items, &FeedItem{Attachment: &model.SlackAttachment{Timestamp: now.Add(-time.Duration(hour) * time.Hour).Unix()}})
+	}
+	sub.recordArrivals(items, now)
+	interval, source = sub.pollInterval(settings, now)
+	assert.Equal(t, 30*time.Minute, interval)
+	assert.Equal(t, IntervalSourceAdaptive, source)
+
+	// quiet for a month, backs off to the maximum
+	interval, _ = sub.pollInterval(settings, now.Add(30*24*time.Hour))
+	assert.Equal(t, 24*time.Hour, interval)
+}
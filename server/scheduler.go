@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errDeactivating is returned when work is started while the plugin is being deactivated
+var errDeactivating = errors.New("the plugin is being deactivated")
+
+// scheduler runs the heartbeat in the background until stopped
+type scheduler struct {
+	ctx    context.Context // cancelled when the scheduler is stopped
+	cancel context.CancelFunc
+	wake   chan struct{}  // processes the heartbeat right away instead of waiting for the next tick
+	done   chan struct{}  // closed once the heartbeat and all fetches it started returned
+	work   sync.WaitGroup // fetches started outside the heartbeat, see beginWork
+}
+
+// startScheduler starts the heartbeat unless it is already running
+func (p *RSSFeedPlugin) startScheduler() {
+	p.schedulerLock.Lock()
+	defer p.schedulerLock.Unlock()
+
+	if p.scheduler != nil {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s := &scheduler{
+		ctx:    ctx,
+		cancel: cancel,
+		wake:   make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	p.scheduler = s
+
+	go p.runScheduler(ctx, s)
+}
+
+// stopScheduler cancels running fetches and waits for them to return
+func (p *RSSFeedPlugin) stopScheduler() {
+	p.schedulerLock.Lock()
+	s := p.scheduler
+	p.scheduler = nil
+	p.schedulerLock.Unlock()
+
+	if s == nil {
+		return
+	}
+
+	s.cancel()
+	<-s.done
+	s.work.Wait()
+}
+
+// beginWork ties work started outside the heartbeat, by commands or pushed content, to the scheduler.
+// The returned context is cancelled along with ctx or when the scheduler is stopped, which waits
+// for done to be called. Returns false if the scheduler isn't running
+func (p *RSSFeedPlugin) beginWork(ctx context.Context) (context.Context, func(), bool) {
+	p.schedulerLock.Lock()
+	s := p.scheduler
+	if s == nil {
+		p.schedulerLock.Unlock()
+		return nil, nil, false
+	}
+	// under the lock, stopScheduler only waits once the scheduler is gone
+	s.work.Add(1)
+	p.schedulerLock.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	done := func() {
+		cancel()
+		s.work.Done()
+	}
+	return ctx, done, true
+}
+
+// goWork runs f in the background as work of the scheduler, see beginWork.
+// Returns false if f wasn't started because the scheduler isn't running
+func (p *RSSFeedPlugin) goWork(f func(ctx context.Context)) bool {
+	ctx, done, ok := p.beginWork(context.Background())
+	if !ok {
+		p.API.LogWarn("Not starting a fetch", "err", errDeactivating.Error())
+		return false
+	}
+
+	go func() {
+		defer done()
+		f(ctx)
+	}()
+	return true
+}
+
+// wakeScheduler processes the heartbeat without waiting for the next tick,
+// e.g. after the polling settings changed
+func (p *RSSFeedPlugin) wakeScheduler() {
+	p.schedulerLock.Lock()
+	defer p.schedulerLock.Unlock()
+
+	if p.scheduler == nil {
+		return
+	}
+
+	select {
+	case p.scheduler.wake <- struct{}{}:
+	default:
+		// a wake up is already pending
+	}
+}
+
+// recheckChannels makes the next heartbeat read every channel, even if its next poll is later,
+// and processes it right away. The stored next polls were computed with the previous settings
+func (p *RSSFeedPlugin) recheckChannels() {
+	atomic.StoreInt32(&p.recheck, 1)
+	p.wakeScheduler()
+}
+
+// runScheduler looks for due subscriptions every schedulerTick,
+// how often each subscription is polled is up to its schedule
+func (p *RSSFeedPlugin) runScheduler(ctx context.Context, s *scheduler) {
+	defer close(s.done)
+
+	if _, err := p.getHeartbeatTime(); err != nil {
+		p.API.LogError(err.Error())
+	}
+
+	for {
+		err := p.processHeartBeat(ctx)
+		if err != nil {
+			p.API.LogError(err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.wake:
+		case <-time.After(schedulerTick):
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// eventually waits up to a second for condition to become true
+func eventually(t *testing.T, condition func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	api, store := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	p := &RSSFeedPlugin{nodeID: "node"}
+	p.SetAPI(api)
+
+	// the first heartbeat runs right away and takes the poller lease
+	p.startScheduler()
+	p.startScheduler()
+	eventually(t, func() bool { return store.get(pollerLockKey) != nil })
+
+	// waking runs another heartbeat without waiting for the tick
+	store.set(pollerLockKey, nil)
+	p.wakeScheduler()
+	eventually(t, func() bool { return store.get(pollerLockKey) != nil })
+
+	// stopping cancels work started outside the heartbeat and waits for it
+	ctx, done, ok := p.beginWork(context.Background())
+	require.True(t, ok)
+
+	stopped := make(chan struct{})
+	go func() {
+		p.stopScheduler()
+		close(stopped)
+	}()
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("the work wasn't cancelled")
+	}
+	select {
+	case <-stopped:
+		t.Fatal("stopped before the work was done")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	done()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("didn't stop once the work was done")
+	}
+
+	_, _, ok = p.beginWork(context.Background())
+	assert.False(t, ok)
+	p.stopScheduler()
+	p.wakeScheduler()
+}
+
+func TestHeartbeatRechecksChannelsAfterSettingsChanged(t *testing.T) {
+	now := time.Now()
+	later := now.Add(50 * time.Minute).Unix()
+	list, _ := json.Marshal(&SubscriptionList{Subscriptions: []*Subscription{
+		{ID: 1, URL: "https://example.com/feed", NextFetch: later},
+	}})
+	channels, _ := json.Marshal([]string{"channel"})
+	api, _ := newKVTestAPI(map[string][]byte{
+		schemaVersionKey:           []byte(strconv.Itoa(schemaVersion)),
+		channelIndexKey:            channels,
+		subscriptionKey("channel"): list,
+		nextPollKey("channel"):     []byte(strconv.FormatInt(later, 10)),
+	})
+	heartbeat := "60"
+	api.On("LoadPluginConfiguration", mock.Anything).Run(func(args mock.Arguments) {
+		args.Get(0).(*configuration).Heartbeat = heartbeat
+	}).Return(nil)
+	p := &RSSFeedPlugin{nodeID: "node"}
+	p.SetAPI(api)
+	require.NoError(t, p.OnConfigurationChange())
+
+	nextFetch := func() int64 {
+		subs, err := p.getSubscriptions("channel")
+		require.NoError(t, err)
+		return subs.Subscriptions[0].NextFetch
+	}
+
+	// not due, the channel isn't read
+	p.recheck = 0
+	require.NoError(t, p.processHeartBeat(context.Background()))
+	assert.Equal(t, later, nextFetch())
+
+	// the shorter interval applies with the next heartbeat
+	heartbeat = "15"
+	require.NoError(t, p.OnConfigurationChange())
+	require.NoError(t, p.processHeartBeat(context.Background()))
+	assert.True(t, nextFetch() <= now.Add(16*time.Minute).Unix())
+	next, err := p.getNextPoll("channel")
+	require.NoError(t, err)
+	assert.Equal(t, nextFetch(), next)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// lower bound of the fingerprints stored per subscription, otherwise twice the size of the feed is kept
+// so items that briefly drop out of it, like when a post is unpinned, aren't posted again
+const minSeenItems = 50
+
+// seenLimit - how many fingerprints are stored for a feed with the given number of items
+func seenLimit(feedSize int) int {
+	if 2*feedSize > minSeenItems {
+		return 2 * feedSize
+	}
+	return minSeenItems
+}
+
+// SeenItem - what is known about an item that has already been posted
+type SeenItem struct {
+	First      int64  // unix time the item was first seen
+	Revision   string `json:",omitempty"` // fingerprint of the content, changes when the item is edited
+	PostID     string `json:",omitempty"` // post the item was posted in, empty if not known
+	Attachment int    `json:",omitempty"` // index of the item's attachment, or line in the compact layout, in the post
+	Layout     string `json:",omitempty"` // layout of the post, empty for attachments
+}
+
+// SeenItems - the items already posted, keyed by the fingerprint of their id
+type SeenItems map[string]*SeenItem
+
+// FeedItem - an item that is new or has changed since it was posted
+type FeedItem struct {
+	Key        string // fingerprint of the item id, key in Subscription.Seen
+	Attachment *model.SlackAttachment
+	Updated    bool   // the item has been posted before
+	PostID     string // post of the previous revision, empty if not known
+	Index      int    // index of the previous revision's attachment, or line, in that post
+	Layout     string // layout of that post, see PostLayout
+	Fields     ItemFields
+	Message    string // message of the post rendered by the message template, see template.go
+}
+
+// itemFingerprint - a short stable identifier for an item built from its identifying fields
+func itemFingerprint(parts ...string) string {
+	h := fnv.New64a()
+	// writing to a hash never fails
+	_, _ = h.Write([]byte(strings.Join(parts, "\x00")))
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// changed is true if the item is new or its revision differs from the one posted,
+// items seen before revisions were tracked count as unchanged
+func (s SeenItems) changed(key string, revision string) bool {
+	seen, ok := s[key]
+	if !ok {
+		return true
+	}
+	return seen.Revision != "" && seen.Revision != revision
+}
+
+// feedItem wraps the attachment of a changed item, must be called before markSeen
+func (s SeenItems) feedItem(key string, attachment *model.SlackAttachment) *FeedItem {
+	item := &FeedItem{Key: key, Attachment: attachment}
+	if seen, ok := s[key]; ok {
+		item.Updated = true
+		item.PostID = seen.PostID
+		item.Index = seen.Attachment
+		item.Layout = seen.Layout
+		if item.Layout == "" {
+			item.Layout = LayoutAttachment
+		}
+	}
+	return item
+}
+
+// markSeen records the fingerprints and revisions of the current feed and drops the oldest entries
+// that are no longer in the feed once there are more than seenLimit,
+// items that left the feed can't be edited anymore so only their fingerprint is kept
+func (sub *Subscription) markSeen(current []string, revisions []string, now int64) {
+	if sub.Seen == nil {
+		sub.Seen = SeenItems{}
+	}
+
+	inFeed := make(map[string]bool, len(current))
+	for index, fingerprint := range current {
+		inFeed[fingerprint] = true
+		seen, ok := sub.Seen[fingerprint]
+		if !ok {
+			seen = &SeenItem{First: now}
+			sub.Seen[fingerprint] = seen
+		}
+		if revisions != nil {
+			seen.Revision = revisions[index]
+		}
+	}
+
+	stale := []string{}
+	for fingerprint, seen := range sub.Seen {
+		if !inFeed[fingerprint] {
+			*seen = SeenItem{First: seen.First}
+			stale = append(stale, fingerprint)
+		}
+	}
+
+	limit := seenLimit(len(current))
+	if len(sub.Seen) <= limit {
+		return
+	}
+	sort.Slice(stale, func(i, j int) bool {
+		return sub.Seen[stale[i]].First < sub.Seen[stale[j]].First
+	})
+
+	for _, fingerprint := range stale {
+		if len(sub.Seen) <= limit {
+			break
+		}
+		delete(sub.Seen, fingerprint)
+	}
+}
+
+// markPosted remembers where an item was posted so later revisions can edit the post
+func (sub *Subscription) markPosted(key string, postID string, attachment int, layout string) {
+	if seen, ok := sub.Seen[key]; ok {
+		seen.PostID = postID
+		seen.Attachment = attachment
+		seen.Layout = layout
+		if layout == LayoutAttachment {
+			seen.Layout = ""
+		}
+	}
+}
+
+// migrateXML replaces the feed document older versions stored with the fingerprints of its items,
+// returns true if the subscription was changed
+func (sub *Subscription) migrateXML(now int64) bool {
+	if sub.XML == "" {
+		return false
+	}
+
+	var fingerprints []string
+	switch sub.Format {
+	case FeedFormatRSSV2:
+		if feed, err := RSSV2ParseString(sub.XML); err == nil {
+			fingerprints = feed.Fingerprints()
+		}
+	case FeedFormatRSSV1:
+		if feed, err := RSSV1ParseString(sub.XML); err == nil {
+			fingerprints = feed.Fingerprints()
+		}
+	}
+
+	sub.markSeen(fingerprints, nil, now)
+	sub.XML = ""
+	return true
+}
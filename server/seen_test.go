@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/tools/blog/atom"
+)
+
+func TestMarkSeenKeepsCurrentItems(t *testing.T) {
+	sub := &Subscription{}
+
+	old := make([]string, minSeenItems)
+	for i := range old {
+		old[i] = itemFingerprint(fmt.Sprint("old", i))
+		sub.markSeen(old[i:i+1], nil, int64(i))
+	}
+
+	current := []string{itemFingerprint("new"), old[0]}
+	assert.True(t, sub.Seen.changed(current[0], ""))
+	assert.False(t, sub.Seen.changed(current[1], ""))
+
+	sub.markSeen(current, nil, minSeenItems)
+	assert.Len(t, sub.Seen, minSeenItems)
+	assert.Contains(t, sub.Seen, current[0])
+	// the oldest entry is still in the feed so it is kept
+	assert.Contains(t, sub.Seen, old[0])
+	assert.NotContains(t, sub.Seen, old[1])
+}
+
+func TestMarkSeenLimitFollowsFeedSize(t *testing.T) {
+	assert.Equal(t, minSeenItems, seenLimit(0))
+	assert.Equal(t, minSeenItems, seenLimit(minSeenItems/2))
+	assert.Equal(t, 200, seenLimit(100))
+
+	sub := &Subscription{}
+	feed := make([]string, 100)
+	for i := range feed {
+		feed[i] = itemFingerprint(fmt.Sprint("first", i))
+	}
+	sub.markSeen(feed, nil, 1)
+
+	// the feed was replaced twice, only the two latest feeds are kept
+	for round := 2; round <= 3; round++ {
+		next := make([]string, len(feed))
+		for i := range next {
+			next[i] = itemFingerprint(fmt.Sprint(round, i))
+		}
+		sub.markSeen(next, nil, int64(round))
+	}
+	assert.Len(t, sub.Seen, 200)
+	assert.NotContains(t, sub.Seen, feed[0])
+}
+
+func TestMarkSeenForgetsPostsOfItemsThatLeftTheFeed(t *testing.T) {
+	sub := &Subscription{}
+	kept, dropped := itemFingerprint("kept"), itemFingerprint("dropped")
+
+	sub.markSeen([]string{kept, dropped}, []string{"a", "b"}, 1)
+	sub.markPosted(kept, "post", 0, LayoutAttachment)
+	sub.markPosted(dropped, "post", 1, LayoutAttachment)
+
+	sub.markSeen([]string{kept}, []string{"a"}, 2)
+	assert.Equal(t, &SeenItem{First: 1, Revision: "a", PostID: "post"}, sub.Seen[kept])
+	assert.Equal(t, &SeenItem{First: 1}, sub.Seen[dropped])
+	// the item isn't posted again when it comes back
+	assert.False(t, sub.Seen.changed(dropped, "c"))
+}
+
+func TestSeenItemsChanged(t *testing.T) {
+	sub := &Subscription{}
+	key := itemFingerprint("1")
+
+	sub.markSeen([]string{key}, []string{"a"}, 1)
+	assert.False(t, sub.Seen.changed(key, "a"))
+	assert.True(t, sub.Seen.changed(key, "b"))
+
+	sub.markPosted(key, "post", 2, LayoutAttachment)
+	item := sub.Seen.feedItem(key, nil)
+	assert.True(t, item.Updated)
+	assert.Equal(t, "post", item.PostID)
+	assert.Equal(t, 2, item.Index)
+
+	// entries migrated from the stored XML have no revision and are never treated as changed
+	seen := SeenItems{key: {First: 5}}
+	assert.False(t, seen.changed(key, "b"))
+}
+
+func TestMigrateXML(t *testing.T) {
+	sub := &Subscription{
+		Format: FeedFormatRSSV2,
+		XML: `<rss version="2.0"><channel>
+			<item><guid>1</guid><title>First</title></item>
+			<item><title>Second</title><link>https://example.com/2</link></item>
+		</channel></rss>`,
+	}
+
+	require.True(t, sub.migrateXML(1))
+	assert.Empty(t, sub.XML)
+
+	feed, err := RSSV2ParseString(`<rss version="2.0"><channel>
+		<item><guid>0</guid><title>Zeroth</title></item>
+		<item><guid>1</guid><title>First, edited</title></item>
+		<item><title>Second</title><link>https://example.com/2</link></item>
+	</channel></rss>`)
+	require.NoError(t, err)
+
+	changed := []int{}
+	for index, fingerprint := range feed.Fingerprints() {
+		if sub.Seen.changed(fingerprint, feed.Channel.ItemList[index].Revision()) {
+			changed = append(changed, index)
+		}
+	}
+	assert.Equal(t, []int{0}, changed)
+
+	assert.False(t, sub.migrateXML(2))
+}
+
+func TestRSSV2ItemRevision(t *testing.T) {
+	item := &Item{Title: "Release", Link: "https://example.com/release", Description: "3 comments"}
+	revision := item.Revision()
+
+	item.Description = "4 comments"
+	assert.Equal(t, revision, item.Revision())
+
+	item.Title = "Release, edited"
+	assert.NotEqual(t, revision, item.Revision())
+}
+
+func TestItemRevisionIgnoresDescriptions(t *testing.T) {
+	atomEntry := func(content string, updated string, title string) *AtomEntry {
+		entry := &AtomEntry{}
+		entry.Title = title
+		entry.Updated = atom.TimeStr(updated)
+		entry.Link = []atom.Link{{Rel: RelAlternate, Href: "https://example.com/release"}}
+		entry.Content = &atom.Text{Body: content}
+		return entry
+	}
+
+	for name, tc := range map[string]struct {
+		revision func(description string, modified string, title string) string
+		modified bool // the format has an explicit modified date
+	}{
+		"rss 1.0": {revision: func(description string, modified string, title string) string {
+			item := &RSSV1Item{Title: title, Link: "https://example.com/release", Description: description, Content: description}
+			return item.Revision()
+		}},
+		"rss 2.0": {revision: func(description string, modified string, title string) string {
+			item := &Item{Title: title, Link: "https://example.com/release", Description: description}
+			return item.Revision()
+		}},
+		"atom": {modified: true, revision: func(description string, modified string, title string) string {
+			return AtomEntryRevision(atomEntry(description, modified, title))
+		}},
+		"json": {modified: true, revision: func(description string, modified string, title string) string {
+			item := &JSONFeedItem{Title: title, URL: "https://example.com/release", ContentHTML: description, ContentText: description, Summary: description, DateModified: modified}
+			return item.Revision()
+		}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			revision := tc.revision("3 comments", "2020-04-24T12:00:00Z", "Release")
+			assert.Equal(t, revision, tc.revision("4 comments", "2020-04-24T12:00:00Z", "Release"))
+			assert.NotEqual(t, revision, tc.revision("3 comments", "2020-04-24T12:00:00Z", "Release, edited"))
+			if tc.modified {
+				assert.NotEqual(t, revision, tc.revision("3 comments", "2020-04-25T12:00:00Z", "Release"))
+			}
+		})
+	}
+}
@@ -0,0 +1,357 @@
+/*
+Layout of the KV store
+
+Keys are namespaced by what they hold:
+
+	sub:<channel id>       the SubscriptionList of a channel
+	chan:<channel id>:...  other state of a channel, e.g. its fetch lock and when it is polled next
+	meta:...               plugin wide state, the schema version, the channel index and the poller lease
+
+The channel index lists the channels that have subscriptions, so the heartbeat never has to guess
+which keys are channels. Older versions stored the subscriptions under the bare channel id,
+migrateStore moves them once and records the schema version so it doesn't run again.
+Until the schema version is recorded, subscriptions are neither read nor written and nothing is polled,
+so nodes that didn't migrate wait for the one that does.
+
+Every change of a channel's subscriptions also stores when the heartbeat has something to do
+for the channel next, so the heartbeat only reads the subscriptions of channels that are due.
+A channel without that key, e.g. stored by an older version, is read by the next heartbeat.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+const (
+	subscriptionKeyPrefix = "sub:"
+	channelKeyPrefix      = "chan:"
+	metaKeyPrefix         = "meta:"
+
+	schemaVersionKey = metaKeyPrefix + "schema_version"
+	channelIndexKey  = metaKeyPrefix + "channels"
+	migrationLockKey = metaKeyPrefix + "lock_migration"
+
+	// bump when the layout changes and add a step to migrateStore
+	schemaVersion = 1
+
+	// attempts of a compare and set before giving up
+	kvSetAttempts = 10
+)
+
+// errKVConflict is returned when a compare and set kept failing because others changed the value
+var errKVConflict = errors.New("the stored data kept changing, please try again")
+
+// errMigrating is returned while the stored data isn't at schemaVersion yet
+var errMigrating = errors.New("the stored subscriptions are being migrated, please try again shortly")
+
+// legacy keys of version 0
+const (
+	legacyPollerLockKey     = "lock_poller"
+	legacyChannelLockPrefix = "lock_channel_"
+)
+
+func subscriptionKey(channelID string) string {
+	return subscriptionKeyPrefix + channelID
+}
+
+func channelKey(channelID string, name string) string {
+	return channelKeyPrefix + channelID + ":" + name
+}
+
+// channelIndex - ids of the channels with subscriptions, sorted
+type channelIndex []string
+
+func (index channelIndex) contains(channelID string) bool {
+	i := sort.SearchStrings(index, channelID)
+	return i < len(index) && index[i] == channelID
+}
+
+// with returns the index with channelID added or removed
+func (index channelIndex) with(channelID string, present bool) channelIndex {
+	i := sort.SearchStrings(index, channelID)
+	found := i < len(index) && index[i] == channelID
+
+	result := make(channelIndex, 0, len(index)+1)
+	result = append(result, index[:i]...)
+	if present {
+		result = append(result, channelID)
+	}
+	if found {
+		i++
+	}
+	return append(result, index[i:]...)
+}
+
+// getChannelIDs returns the ids of all channels with stored subscriptions
+func (p *RSSFeedPlugin) getChannelIDs() ([]string, error) {
+	index, _, err := p.getChannelIndex()
+	if err != nil {
+		return nil, err
+	}
+	return index, nil
+}
+
+// getChannelIndex returns the index and its stored value for a compare and set
+func (p *RSSFeedPlugin) getChannelIndex() (channelIndex, []byte, error) {
+	value, appErr := p.API.KVGet(channelIndexKey)
+	if appErr != nil {
+		return nil, nil, appErr
+	}
+
+	index := channelIndex{}
+	if value != nil {
+		if err := json.Unmarshal(value, &index); err != nil {
+			return nil, nil, err
+		}
+		sort.Strings(index)
+	}
+	return index, value, nil
+}
+
+// indexChannel adds the channel to the channel index or removes it
+func (p *RSSFeedPlugin) indexChannel(channelID string, present bool) error {
+	for attempt := 0; attempt < kvSetAttempts; attempt++ {
+		index, current, err := p.getChannelIndex()
+		if err != nil {
+			return err
+		}
+		if index.contains(channelID) == present {
+			return nil
+		}
+
+		next, err := json.Marshal(index.with(channelID, present))
+		if err != nil {
+			return err
+		}
+
+		ok, appErr := p.API.KVCompareAndSet(channelIndexKey, current, next)
+		if appErr != nil {
+			return appErr
+		}
+		if ok {
+			return nil
+		}
+	}
+	return errKVConflict
+}
+
+// nextPollKey - unix time at which the heartbeat reads the subscriptions of the channel again
+func nextPollKey(channelID string) string {
+	return channelKey(channelID, "next")
+}
+
+// getNextPoll returns when the channel has to be read by the heartbeat, 0 if it is unknown
+func (p *RSSFeedPlugin) getNextPoll(channelID string) (int64, error) {
+	value, appErr := p.API.KVGet(nextPollKey(channelID))
+	if appErr != nil {
+		return 0, appErr
+	}
+	if value == nil {
+		return 0, nil
+	}
+	return strconv.ParseInt(string(value), 10, 64)
+}
+
+// storeNextPoll records when the heartbeat has something to do for the subscriptions next
+func (p *RSSFeedPlugin) storeNextPoll(channelID string, subs *SubscriptionList) {
+	var appErr *model.AppError
+	if len(subs.Subscriptions) == 0 {
+		appErr = p.API.KVDelete(nextPollKey(channelID))
+	} else {
+		next := subs.nextPoll(time.Now())
+		appErr = p.API.KVSet(nextPollKey(channelID), []byte(strconv.FormatInt(next, 10)))
+	}
+	if appErr != nil {
+		// a stale time is corrected within channelRecheckInterval
+		p.API.LogError("Failed to store the next poll of the channel", "channel_id", channelID, "err", appErr.Error())
+	}
+}
+
+// getSchemaVersion - version of the stored layout, 0 before the keys were namespaced
+func (p *RSSFeedPlugin) getSchemaVersion() (int, error) {
+	value, appErr := p.API.KVGet(schemaVersionKey)
+	if appErr != nil {
+		return 0, appErr
+	}
+	if value == nil {
+		return 0, nil
+	}
+	return strconv.Atoi(string(value))
+}
+
+// checkStoreReady returns errMigrating until the stored data is at schemaVersion
+func (p *RSSFeedPlugin) checkStoreReady() error {
+	if atomic.LoadInt32(&p.storeReady) == 1 {
+		return nil
+	}
+
+	version, err := p.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version < schemaVersion {
+		return errMigrating
+	}
+	atomic.StoreInt32(&p.storeReady, 1)
+	return nil
+}
+
+// migrateStore brings the stored data up to schemaVersion,
+// on a cluster only one node migrates, the others find the new version once it is done, see checkStoreReady
+func (p *RSSFeedPlugin) migrateStore() error {
+	version, err := p.getSchemaVersion()
+	if err != nil {
+		return err
+	}
+	if version >= schemaVersion {
+		atomic.StoreInt32(&p.storeReady, 1)
+		return nil
+	}
+
+	ok, err := p.tryLock(migrationLockKey, p.nodeID, 10*time.Minute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		p.API.LogInfo("Another node is migrating the stored subscriptions")
+		return nil
+	}
+	defer func() {
+		if err := p.unlock(migrationLockKey, p.nodeID); err != nil {
+			p.API.LogError("Failed to release the migration lock", "err", err.Error())
+		}
+	}()
+
+	// another node might have finished before we took the lock
+	if version, err = p.getSchemaVersion(); err != nil || version >= schemaVersion {
+		return err
+	}
+
+	if version < 1 {
+		if err := p.migrateToNamespacedKeys(); err != nil {
+			return err
+		}
+	}
+
+	if appErr := p.API.KVSet(schemaVersionKey, []byte(strconv.Itoa(schemaVersion))); appErr != nil {
+		return appErr
+	}
+	atomic.StoreInt32(&p.storeReady, 1)
+	p.API.LogInfo("Migrated the stored subscriptions", "from", version, "to", schemaVersion)
+	return nil
+}
+
+// migrateToNamespacedKeys moves the subscriptions stored under bare channel ids to sub: keys,
+// it can be interrupted and run again: the legacy key is only deleted once its subscriptions were stored
+func (p *RSSFeedPlugin) migrateToNamespacedKeys() error {
+	keys, err := p.listKeys()
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		switch {
+		case key == legacyPollerLockKey || strings.HasPrefix(key, legacyChannelLockPrefix):
+			if appErr := p.API.KVDelete(key); appErr != nil {
+				return appErr
+			}
+
+		case model.IsValidId(key):
+			value, appErr := p.API.KVGet(key)
+			if appErr != nil {
+				return appErr
+			}
+			if value != nil {
+				moved, err := p.moveLegacySubscriptions(key, value)
+				if err != nil {
+					return err
+				}
+				if !moved {
+					continue
+				}
+			}
+			if appErr := p.API.KVDelete(key); appErr != nil {
+				return appErr
+			}
+		}
+	}
+	return nil
+}
+
+// moveLegacySubscriptions stores the subscriptions a channel had under its bare id under its sub: key,
+// merged with the subscriptions stored there already, e.g. by an interrupted migration.
+// Returns false if the legacy value can't be decoded and has to be kept
+func (p *RSSFeedPlugin) moveLegacySubscriptions(channelID string, legacy []byte) (bool, error) {
+	legacyList, decodeErr := decodeSubscriptions(legacy)
+
+	for attempt := 0; attempt < kvSetAttempts; attempt++ {
+		current, appErr := p.API.KVGet(subscriptionKey(channelID))
+		if appErr != nil {
+			return false, appErr
+		}
+
+		next := legacy
+		if current != nil {
+			if bytes.Equal(current, legacy) {
+				return true, p.indexChannel(channelID, true)
+			}
+			if decodeErr != nil {
+				p.API.LogError("Keeping subscriptions that can't be decoded", "key", channelID, "err", decodeErr.Error())
+				return false, nil
+			}
+
+			merged, err := decodeSubscriptions(current)
+			if err != nil {
+				return false, err
+			}
+			for _, sub := range legacyList.Subscriptions {
+				if existing, _ := merged.find(sub.URL); existing == nil {
+					merged.addpend(sub)
+				}
+			}
+			if next, err = json.Marshal(merged); err != nil {
+				return false, err
+			}
+		}
+
+		// nil only matches if the key doesn't exist
+		ok, appErr := p.API.KVCompareAndSet(subscriptionKey(channelID), current, next)
+		if appErr != nil {
+			return false, appErr
+		}
+		if ok {
+			return true, p.indexChannel(channelID, true)
+		}
+	}
+	return false, errKVConflict
+}
+
+// listKeys returns all keys of the plugin
+func (p *RSSFeedPlugin) listKeys() ([]string, error) {
+	const keysPerPage = 50
+
+	result := []string{}
+	for page := 0; true; page++ {
+		keys, appErr := p.API.KVList(page, keysPerPage)
+		if appErr != nil {
+			return nil, appErr
+		}
+		result = append(result, keys...)
+
+		if len(keys) < keysPerPage {
+			break
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChannelIndex(t *testing.T) {
+	index := channelIndex{}
+	index = index.with("b", true)
+	index = index.with("a", true)
+	index = index.with("c", true)
+	index = index.with("a", true)
+	assert.Equal(t, channelIndex{"a", "b", "c"}, index)
+	assert.True(t, index.contains("b"))
+
+	index = index.with("b", false)
+	index = index.with("d", false)
+	assert.Equal(t, channelIndex{"a", "c"}, index)
+	assert.False(t, index.contains("b"))
+}
+
+func marshalSubscriptions(t *testing.T, urls ...string) []byte {
+	list := &SubscriptionList{Subscriptions: []*Subscription{}}
+	for _, url := range urls {
+		list.addpend(&Subscription{URL: url, ID: makeHash(url)})
+	}
+	value, err := json.Marshal(list)
+	require.NoError(t, err)
+	return value
+}
+
+func TestMigrateStore(t *testing.T) {
+	moved := model.NewId()
+	written := model.NewId()
+	values := map[string][]byte{
+		moved:   marshalSubscriptions(t, "https://example.com/a"),
+		written: marshalSubscriptions(t, "https://example.com/b", "https://example.com/c"),
+		// subscribed on a node that didn't wait for the migration
+		subscriptionKey(written):             marshalSubscriptions(t, "https://example.com/c", "https://example.com/d"),
+		legacyPollerLockKey:                  []byte("{}"),
+		legacyChannelLockPrefix + moved:      []byte("{}"),
+		"some_key_of_a_newer_version_or_bug": []byte("x"),
+	}
+	api, store := newKVTestAPI(values)
+	p := &RSSFeedPlugin{nodeID: "node"}
+	p.SetAPI(api)
+
+	// nothing is read before the migration
+	_, err := p.getSubscriptions(moved)
+	assert.Equal(t, errMigrating, err)
+
+	require.NoError(t, p.migrateStore())
+	assert.Equal(t, strconv.Itoa(schemaVersion), string(store.get(schemaVersionKey)))
+
+	subs, err := p.getSubscriptions(moved)
+	require.NoError(t, err)
+	assert.Len(t, subs.Subscriptions, 1)
+
+	subs, err = p.getSubscriptions(written)
+	require.NoError(t, err)
+	urls := []string{}
+	for _, sub := range subs.Subscriptions {
+		urls = append(urls, sub.URL)
+	}
+	assert.Equal(t, []string{"https://example.com/c", "https://example.com/d", "https://example.com/b"}, urls)
+
+	channelIDs, err := p.getChannelIDs()
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{moved, written}, channelIDs)
+
+	for _, key := range []string{moved, written, legacyPollerLockKey, legacyChannelLockPrefix + moved} {
+		assert.Nil(t, store.get(key), key)
+	}
+	assert.NotNil(t, store.get("some_key_of_a_newer_version_or_bug"))
+}
+
+func TestMigrateStoreOnAnotherNode(t *testing.T) {
+	channelID := model.NewId()
+	lease, err := json.Marshal(kvLease{Holder: "other", Expires: time.Now().Add(time.Minute).Unix()})
+	require.NoError(t, err)
+	api, store := newKVTestAPI(map[string][]byte{
+		channelID:        marshalSubscriptions(t, "https://example.com/a"),
+		migrationLockKey: lease,
+	})
+	p := &RSSFeedPlugin{nodeID: "node"}
+	p.SetAPI(api)
+
+	// the other node migrates, this one neither reads nor writes until it is done
+	require.NoError(t, p.migrateStore())
+	assert.NotNil(t, store.get(channelID))
+	err = p.modifySubscriptions(channelID, func(subs *SubscriptionList) error {
+		subs.addpend(&Subscription{URL: "https://example.com/b"})
+		return nil
+	})
+	assert.Equal(t, errMigrating, err)
+	assert.Nil(t, store.get(subscriptionKey(channelID)))
+	assert.Equal(t, errMigrating, p.checkStoreReady())
+
+	store.set(schemaVersionKey, []byte(strconv.Itoa(schemaVersion)))
+	assert.NoError(t, p.checkStoreReady())
+}
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"hash/fnv"
 	"math/rand"
+	"reflect"
+	"time"
 
 	"github.com/mattermost/mattermost-server/model"
 )
@@ -15,7 +17,7 @@ import (
 // Subscription Object
 type Subscription struct {
 	URL       string
-	XML       string
+	XML       string `json:",omitempty"` // last feed document of older versions, migrated to Seen on load
 	Timestamp int64
 	ETag      string
 	Format    FeedFormat
@@ -23,6 +25,53 @@ type Subscription struct {
 	Color     string
 	ID        uint32
 	UserID    string // the user who created the subscription
+	Alternate string // link to the website of the feed
+	SelfLink  string `json:",omitempty"` // last rel="self" link of the feed that was tried, see applySelfLink
+	Seen      SeenItems
+	Updates   string        // how changes to posted items are handled, see UpdateMode
+	Filters   []*FilterRule `json:",omitempty"` // see filter.go
+	Template  string        `json:",omitempty"` // message template, see template.go
+	Layout    string        `json:",omitempty"` // see PostLayout
+
+	// thread items are posted in, see thread.go
+	Thread       string `json:",omitempty"` // see ThreadMode
+	ThreadRoot   string `json:",omitempty"` // id of the root post of the current thread
+	ThreadPeriod string `json:",omitempty"` // period ThreadRoot was posted for, see threadPeriod
+
+	// fetch health, see health.go
+	LastSuccess int64  // unix time of the last successful fetch
+	Failures    int    // consecutive failed fetches
+	LastError   string // error of the last failed fetch
+	Paused      bool   // paused subscriptions are not fetched
+
+	// polling schedule, see schedule.go
+	Interval  int            // minutes between polls set by the user, 0 uses the heartbeat
+	NextFetch int64          // unix time of the next poll, 0 until scheduled
+	TTL       int            // minutes the feed may be cached according to <ttl>
+	SkipHours []int          // hours (UTC) the feed asks not to be polled in
+	SkipDays  []time.Weekday // days the feed asks not to be polled on
+	Arrivals  []int64        // unix times the latest items arrived, oldest first
+
+	// HTTP caching, see cache.go
+	LastModified string
+	CacheUntil   int64 // unix time until which the last response is fresh
+	CacheStats   CacheStats
+
+	// WebSub push subscription, empty Hub if the feed doesn't advertise one
+	Hub          string
+	Topic        string // url the hub knows the feed by
+	HubSecret    string // shared secret for X-Hub-Signature
+	HubLeaseEnd  int64  // unix time the hub subscription expires, 0 until verified
+	HubRequested int64  // unix time of the last subscription request to the hub
+
+	// rssCloud notifications, empty Cloud if the feed doesn't have a http-post <cloud>
+	Cloud           string // registration endpoint
+	CloudToken      string // part of the callback path, only the cloud knows it
+	CloudRegistered int64  // unix time of the last registration
+}
+
+func (s *Subscription) markdownLink() string {
+	return fmt.Sprintf("[%s](%s)", s.Title, s.URL)
 }
 
 type SubscriptionList struct {
@@ -56,32 +105,62 @@ func (s *SubscriptionList) remove(index int) {
 	s.Subscriptions = append(s.Subscriptions[:index], s.Subscriptions[index+1:]...)
 }
 
+// removeDuplicates removes subscriptions that moved to the url of another one and returns them,
+// base is the list before they moved. Of duplicates that all moved, or none, the first one is kept
+func (s *SubscriptionList) removeDuplicates(base *SubscriptionList) []*Subscription {
+	moved := func(sub *Subscription) bool {
+		baseSub, _ := base.findID(sub.ID)
+		return baseSub != nil && baseSub.URL != sub.URL
+	}
+
+	removed := []*Subscription{}
+	for index := len(s.Subscriptions) - 1; index >= 0; index-- {
+		sub := s.Subscriptions[index]
+
+		keep := -1
+		for i, other := range s.Subscriptions {
+			if other.URL == sub.URL && (keep == -1 || (moved(s.Subscriptions[keep]) && !moved(other))) {
+				keep = i
+			}
+		}
+		if keep != index {
+			s.remove(index)
+			removed = append(removed, sub)
+		}
+	}
+	return removed
+}
+
 func (s *SubscriptionList) addpend(sub *Subscription) {
 	s.Subscriptions = append(s.Subscriptions, sub)
 }
 
+// errAlreadySubscribed is returned by addSubscription when the channel already has the feed
+var errAlreadySubscribed = errors.New("this channel is already subscribed to that feed")
+
+// discoveredFeedsError is returned by createSubscription when the url is a
+// website advertising more than one feed, the user has to pick one
+type discoveredFeedsError struct {
+	links []*FeedLink
+}
+
+func (e *discoveredFeedsError) Error() string {
+	return fmt.Sprintf("the page advertises %d feeds", len(e.links))
+}
+
 // Subscribe process the /feed subscribe <channel> <url>
 func (p *RSSFeedPlugin) subscribe(ctx context.Context, url string, channelID string, userID string) {
-	sub := &Subscription{
-		URL:    url,
-		XML:    "",
-		Color:  hashColor(url),
-		ID:     makeHash(url),
-		UserID: userID,
-	}
-
-	info, err := p.FetchFeedInfo(url)
+	sub, info, err := p.createSubscription(ctx, url, channelID, userID)
 
-	if err == nil {
-		sub.Title = info.Title
-		sub.Format = info.Format
-		err = p.addSubscription(channelID, sub)
+	if discovered, ok := err.(*discoveredFeedsError); ok {
+		p.postDiscoveredFeeds(url, channelID, userID, discovered.links)
+		return
 	}
 
 	if err != nil {
 		p.API.LogError(err.Error())
 		msg := fmt.Sprintf("Failed to subscribe to %s: `%s`", url, err.Error())
-		p.createBotPost(msg, channelID, userID, nil)
+		p.createBotPost(msg, channelID, userID, "", nil)
 		return
 	}
 
@@ -103,33 +182,157 @@ func (p *RSSFeedPlugin) subscribe(ctx context.Context, url string, channelID str
 		},
 	}
 
-	p.createBotPost("Subscribed to:", channelID, "", []*model.SlackAttachment{attachment})
+	p.createBotPost("Subscribed to:", channelID, "", "", []*model.SlackAttachment{attachment})
 }
 
-func (p *RSSFeedPlugin) addSubscription(channelID string, sub *Subscription) error {
-	subList, err := p.getSubscriptions(channelID)
+// createSubscription fetches the feed at url and adds it to the channel,
+// falling back to feed autodiscovery when the url is a website
+func (p *RSSFeedPlugin) createSubscription(ctx context.Context, url string, channelID string, userID string) (*Subscription, *FeedInfo, error) {
+	ctx = withFetchPool(ctx, p.getFetchPool())
+	info, err := p.FetchFeedInfo(ctx, url)
+
+	if notFeed, ok := err.(*notAFeedError); ok {
+		// the url may be a website advertising its feeds
+		if len(notFeed.links) > 1 {
+			return nil, nil, &discoveredFeedsError{links: notFeed.links}
+		}
+		if len(notFeed.links) == 1 {
+			url = notFeed.links[0].URL
+			info, err = p.FetchFeedInfo(ctx, url)
+		}
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sub := &Subscription{
+		URL:       url,
+		Title:     info.Title,
+		Format:    info.Format,
+		Color:     hashColor(url),
+		ID:        makeHash(url),
+		UserID:    userID,
+		Alternate: info.Alternate,
+	}
+
+	if info.Hub != "" {
+		secret, err := newWebSubSecret()
+		if err != nil {
+			// pushed content couldn't be verified, the feed is only polled
+			p.API.LogWarn("Not subscribing to WebSub hub", "hub", info.Hub, "err", err.Error())
+		} else {
+			sub.Hub = info.Hub
+			sub.Topic = info.Self
+			if sub.Topic == "" {
+				sub.Topic = url
+			}
+			sub.HubSecret = secret
+			sub.HubRequested = time.Now().Unix()
+		}
+	}
+
+	if info.Cloud != "" {
+		token, err := newRSSCloudToken()
+		if err != nil {
+			// notifications couldn't be told apart from anyone else's requests, the feed is only polled
+			p.API.LogWarn("Not registering with rssCloud", "cloud", info.Cloud, "err", err.Error())
+		} else {
+			sub.Cloud = info.Cloud
+			sub.CloudToken = token
+			sub.CloudRegistered = time.Now().Unix()
+		}
+	}
+
+	if err := p.addSubscription(channelID, sub); err != nil {
+		return nil, nil, err
+	}
+
+	if sub.Hub != "" {
+		p.goWork(func(ctx context.Context) {
+			p.requestWebSub(ctx, channelID, sub, WebSubModeSubscribe)
+		})
+	}
+	if sub.Cloud != "" {
+		p.goWork(func(ctx context.Context) {
+			p.registerRSSCloud(ctx, channelID, sub)
+		})
+	}
+
+	return sub, info, nil
+}
+
+// postDiscoveredFeeds lets the user pick one of the feeds a website advertises
+func (p *RSSFeedPlugin) postDiscoveredFeeds(pageURL string, channelID string, userID string, links []*FeedLink) {
+	urls := make([]string, len(links))
+	titles := make([]string, len(links))
+	for i, link := range links {
+		urls[i] = link.URL
+		titles[i] = link.Title
+	}
+
+	attachment := p.makeDiscoverAttachments(pageURL, urls, titles, "")
+	p.createBotPost("This page has multiple feeds, choose one to subscribe to:", channelID, userID, "", []*model.SlackAttachment{attachment})
+}
+
+// subscribeDiscovered subscribes to the feed picked from the ones discovered on the page,
+// the pick comes from the client so the page is asked again whether it advertises the feed
+func (p *RSSFeedPlugin) subscribeDiscovered(ctx context.Context, pageURL string, feedURL string, channelID string, userID string) {
+	links, err := p.DiscoverFeeds(withFetchPool(ctx, p.getFetchPool()), pageURL)
 	if err != nil {
 		p.API.LogError(err.Error())
-		return err
+		msg := fmt.Sprintf("Failed to subscribe to %s: `%s`", feedURL, err.Error())
+		p.createBotPost(msg, channelID, userID, "", nil)
+		return
 	}
 
-	// check if url already exists
-	_, index := subList.find(sub.URL)
-	if index != -1 {
-		return errors.New("this channel is already subscribed to that feed")
+	for _, link := range links {
+		if link.URL == feedURL {
+			p.subscribe(ctx, feedURL, channelID, userID)
+			return
+		}
 	}
-	subList.addpend(sub)
-	return p.storeSubscriptions(channelID, subList)
+	p.createBotPost(fmt.Sprintf("Failed to subscribe to %s: %s doesn't advertise it", feedURL, pageURL), channelID, userID, "", nil)
+}
+
+func (p *RSSFeedPlugin) addSubscription(channelID string, sub *Subscription) error {
+	return p.modifySubscriptions(channelID, func(subList *SubscriptionList) error {
+		// check if url already exists
+		_, index := subList.find(sub.URL)
+		if index != -1 {
+			return errAlreadySubscribed
+		}
+		subList.addpend(sub)
+		return nil
+	})
 }
 
 func (p *RSSFeedPlugin) getSubscriptions(channelID string) (*SubscriptionList, error) {
-	var subList *SubscriptionList
+	subList, _, err := p.loadSubscriptions(channelID)
+	return subList, err
+}
+
+// loadSubscriptions returns the subscriptions of the channel and the stored value they were decoded from
+func (p *RSSFeedPlugin) loadSubscriptions(channelID string) (*SubscriptionList, []byte, error) {
+	if err := p.checkStoreReady(); err != nil {
+		return nil, nil, err
+	}
+
+	value, appErr := p.API.KVGet(subscriptionKey(channelID))
+	if appErr != nil {
+		p.API.LogError(appErr.Error())
+		return nil, nil, appErr
+	}
 
-	value, err := p.API.KVGet(channelID)
+	subList, err := decodeSubscriptions(value)
 	if err != nil {
-		p.API.LogError(err.Error())
-		return nil, err
+		return nil, nil, err
 	}
+	return subList, value, nil
+}
+
+func decodeSubscriptions(value []byte) (*SubscriptionList, error) {
+	var subList *SubscriptionList
 
 	if value == nil {
 		subList = &SubscriptionList{Subscriptions: []*Subscription{}}
@@ -155,42 +358,222 @@ func (p *RSSFeedPlugin) getSubscriptions(channelID string) (*SubscriptionList, e
 		}
 	}
 
+	// stored with the next change of the channel's subscriptions
+	now := time.Now().Unix()
+	for _, sub := range subList.Subscriptions {
+		sub.migrateXML(now)
+	}
+
 	return subList, nil
 }
 
-func (p *RSSFeedPlugin) storeSubscriptions(channelID string, s *SubscriptionList) error {
+// clone returns a deep copy of the list
+func (s *SubscriptionList) clone() *SubscriptionList {
 	b, err := json.Marshal(s)
 	if err != nil {
-		p.API.LogError(err.Error())
+		return &SubscriptionList{}
+	}
+	clone := &SubscriptionList{}
+	if err := json.Unmarshal(b, clone); err != nil {
+		return &SubscriptionList{}
+	}
+	return clone
+}
+
+// modifySubscriptions loads the channel's subscriptions, applies modify and stores them
+// unless someone else stored them in the meantime, in which case it starts over with their changes.
+// modify may run more than once and should not have side effects, nothing is stored if it returns an error.
+//
+// Once the last subscription is removed the empty list stays stored: the plugin API has no compare and delete,
+// deleting the key could drop a subscription added at the same time. The channel leaves the channel index
+// so the heartbeat doesn't read it anymore
+func (p *RSSFeedPlugin) modifySubscriptions(channelID string, modify func(*SubscriptionList) error) error {
+	for attempt := 0; attempt < kvSetAttempts; attempt++ {
+		subList, current, err := p.loadSubscriptions(channelID)
+		if err != nil {
+			return err
+		}
+
+		if err := modify(subList); err != nil {
+			return err
+		}
+
+		next, err := json.Marshal(subList)
+		if err != nil {
+			p.API.LogError(err.Error())
+			return err
+		}
+		if bytes.Equal(current, next) || (current == nil && len(subList.Subscriptions) == 0) {
+			return nil
+		}
+
+		// the index may list a channel without subscriptions but never miss one with subscriptions
+		if len(subList.Subscriptions) > 0 {
+			if err := p.indexChannel(channelID, true); err != nil {
+				return err
+			}
+		}
+
+		// nil only matches if the key doesn't exist
+		ok, appErr := p.API.KVCompareAndSet(subscriptionKey(channelID), current, next)
+		if appErr != nil {
+			return appErr
+		}
+		if !ok {
+			continue
+		}
+
+		p.storeNextPoll(channelID, subList)
+		if len(subList.Subscriptions) == 0 {
+			return p.unindexChannel(channelID)
+		}
+		return nil
+	}
+	return errKVConflict
+}
+
+// unindexChannel removes a channel without subscriptions from the channel index,
+// adding it back if a subscription was added at the same time
+func (p *RSSFeedPlugin) unindexChannel(channelID string) error {
+	if err := p.indexChannel(channelID, false); err != nil {
 		return err
 	}
 
-	if err := p.API.KVSet(channelID, b); err != nil {
+	subList, err := p.getSubscriptions(channelID)
+	if err != nil {
 		return err
 	}
+	if len(subList.Subscriptions) > 0 {
+		return p.indexChannel(channelID, true)
+	}
 	return nil
 }
 
-func (p *RSSFeedPlugin) unsubscribeFromID(channelID string, id uint32) error {
+// updateSubscription loads the subscription, applies update and stores the channel's subscriptions,
+// nothing is stored if update returns an error. update may run more than once, see modifySubscriptions
+func (p *RSSFeedPlugin) updateSubscription(channelID string, id uint32, update func(*Subscription) error) error {
+	return p.modifySubscriptions(channelID, func(subs *SubscriptionList) error {
+		sub, _ := subs.findID(id)
+		if sub == nil {
+			return errors.New("id not found")
+		}
+		return update(sub)
+	})
+}
+
+// fetchSubscription runs fetch on the stored subscription and merges the fetch state it leaves behind
+// into the subscriptions stored by then, see storeFetchState. fetch runs once and may post,
+// nothing is stored if it returns an error
+func (p *RSSFeedPlugin) fetchSubscription(channelID string, id uint32, fetch func(*Subscription) error) error {
 	subs, err := p.getSubscriptions(channelID)
 	if err != nil {
-		p.API.LogError(err.Error())
 		return err
 	}
+	base := subs.clone()
 
-	sub, index := subs.findID(id)
+	sub, _ := subs.findID(id)
+	if sub == nil {
+		return errors.New("id not found")
+	}
 
-	if index != -1 {
-		subs.remove(index)
-		if err := p.storeSubscriptions(channelID, subs); err != nil {
-			p.API.LogError(err.Error())
-			return err
+	if err := fetch(sub); err != nil {
+		return err
+	}
+
+	return p.storeFetchState(channelID, base, subs)
+}
+
+// storeFetchState stores what fetching changed, base is the list as loaded before fetching and fetched the list after.
+// Subscriptions added or removed by commands in the meantime stay added or removed, see mergeSubscription for the fields
+func (p *RSSFeedPlugin) storeFetchState(channelID string, base *SubscriptionList, fetched *SubscriptionList) error {
+	var removed []*Subscription
+	err := p.modifySubscriptions(channelID, func(current *SubscriptionList) error {
+		for _, sub := range current.Subscriptions {
+			baseSub, _ := base.findID(sub.ID)
+			fetchedSub, _ := fetched.findID(sub.ID)
+			if baseSub == nil || fetchedSub == nil {
+				// subscribed while fetching
+				continue
+			}
+			mergeSubscription(sub, baseSub, fetchedSub)
+		}
+
+		removed = current.removeDuplicates(base)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, sub := range removed {
+		if sub.Hub != "" {
+			sub := sub
+			p.goWork(func(ctx context.Context) {
+				p.requestWebSub(ctx, channelID, sub, WebSubModeUnsubscribe)
+			})
+		}
+		p.createBotPost(fmt.Sprintf("Removed %s, the feed moved to a url this channel is already subscribed to", sub.markdownLink()), channelID, "", "", nil)
+	}
+	return nil
+}
+
+// commandFields - fields of Subscription set by commands, if a command changed one of them
+// while the subscription was being fetched the command wins over the fetch
+var commandFields = map[string]bool{
+	"Title":     true,
+	"Color":     true,
+	"Updates":   true,
+	"Filters":   true,
+	"Template":  true,
+	"Layout":    true,
+	"Thread":    true,
+	"Paused":    true,
+	"Interval":  true,
+	"NextFetch": true,
+}
+
+// mergeSubscription applies the fields fetching changed from base to fetched onto current,
+// current holds whatever was stored in the meantime
+func mergeSubscription(current *Subscription, base *Subscription, fetched *Subscription) {
+	c := reflect.ValueOf(current).Elem()
+	b := reflect.ValueOf(base).Elem()
+	f := reflect.ValueOf(fetched).Elem()
+
+	for i := 0; i < c.NumField(); i++ {
+		if reflect.DeepEqual(f.Field(i).Interface(), b.Field(i).Interface()) {
+			// not changed by fetching
+			continue
+		}
+		if commandFields[c.Type().Field(i).Name] && !reflect.DeepEqual(c.Field(i).Interface(), b.Field(i).Interface()) {
+			continue
 		}
-		p.createBotPost(fmt.Sprintf("Unsubscribed from %s", sub.Title), channelID, "", nil)
+		c.Field(i).Set(f.Field(i))
+	}
+}
+
+func (p *RSSFeedPlugin) unsubscribeFromID(channelID string, id uint32) error {
+	var sub *Subscription
+	err := p.modifySubscriptions(channelID, func(subs *SubscriptionList) error {
+		var index int
+		sub, index = subs.findID(id)
+		if index == -1 {
+			return errors.New("id not found")
+		}
+		subs.remove(index)
 		return nil
+	})
+	if err != nil {
+		p.API.LogError(err.Error())
+		return err
 	}
 
-	return errors.New("id not found")
+	if sub.Hub != "" {
+		p.goWork(func(ctx context.Context) {
+			p.requestWebSub(ctx, channelID, sub, WebSubModeUnsubscribe)
+		})
+	}
+	p.createBotPost(fmt.Sprintf("Unsubscribed from %s", sub.Title), channelID, "", "", nil)
+	return nil
 }
 
 func makeHash(s string) uint32 {
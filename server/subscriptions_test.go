@@ -1,9 +1,222 @@
 package main
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSubscribe(t *testing.T) {
 
 }
+
+func TestRemoveDuplicates(t *testing.T) {
+	list := &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://example.com/a", ID: 1},
+		{URL: "https://example.com/b", ID: 2},
+		{URL: "https://example.com/a", ID: 3},
+	}}
+
+	base := &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://example.com/a", ID: 1},
+		{URL: "https://example.com/b", ID: 2},
+		{URL: "https://example.com/a", ID: 3},
+	}}
+
+	removed := list.removeDuplicates(base)
+
+	assert.Len(t, removed, 1)
+	assert.Equal(t, uint32(3), removed[0].ID)
+	assert.Len(t, list.Subscriptions, 2)
+
+	// the subscription that moved is removed, not the one listed later
+	list = &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://example.com/b", ID: 1},
+		{URL: "https://example.com/b", ID: 2},
+	}}
+	base = &SubscriptionList{Subscriptions: []*Subscription{
+		{URL: "https://example.com/a", ID: 1},
+		{URL: "https://example.com/b", ID: 2},
+	}}
+
+	removed = list.removeDuplicates(base)
+
+	assert.Len(t, removed, 1)
+	assert.Equal(t, uint32(1), removed[0].ID)
+	assert.Equal(t, uint32(2), list.Subscriptions[0].ID)
+}
+
+func TestStoreFetchStateUnsubscribesRemovedDuplicates(t *testing.T) {
+	api, _ := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	siteURL := "https://mattermost.example.com"
+	api.On("GetConfig").Return(&model.Config{ServiceSettings: model.ServiceSettings{SiteURL: &siteURL}})
+	api.On("CreatePost", mock.Anything).Return(&model.Post{}, nil)
+
+	requests := make(chan *http.Request, 1)
+	p := &RSSFeedPlugin{nodeID: "node", FeedHandler: FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		requests <- req
+		return &http.Response{StatusCode: http.StatusAccepted, Body: ioutil.NopCloser(strings.NewReader(""))}, nil
+	})}}
+	p.SetAPI(api)
+	p.startScheduler()
+	defer p.stopScheduler()
+
+	base := &SubscriptionList{Subscriptions: []*Subscription{
+		{ID: 1, URL: "https://example.com/a", Hub: "https://hub.example.com", Topic: "https://example.com/a", HubSecret: "secret"},
+		{ID: 2, URL: "https://example.com/b"},
+	}}
+	for _, sub := range base.Subscriptions {
+		require.NoError(t, p.addSubscription("channel", sub))
+	}
+
+	fetched := &SubscriptionList{Subscriptions: []*Subscription{
+		{ID: 1, URL: "https://example.com/b", Hub: "https://hub.example.com", Topic: "https://example.com/a", HubSecret: "secret"},
+		{ID: 2, URL: "https://example.com/b"},
+	}}
+	require.NoError(t, p.storeFetchState("channel", base, fetched))
+
+	subs, err := p.getSubscriptions("channel")
+	require.NoError(t, err)
+	require.Len(t, subs.Subscriptions, 1)
+	assert.Equal(t, uint32(2), subs.Subscriptions[0].ID)
+
+	select {
+	case req := <-requests:
+		assert.Equal(t, "https://hub.example.com", req.URL.String())
+		assert.Equal(t, WebSubModeUnsubscribe, req.FormValue("hub.mode"))
+		assert.Equal(t, "https://example.com/a", req.FormValue("hub.topic"))
+		assert.Equal(t, siteURL+"/plugins/rssfeed/websub/channel/1", req.FormValue("hub.callback"))
+	case <-time.After(time.Second):
+		t.Fatal("the hub wasn't asked to unsubscribe")
+	}
+}
+
+func TestMergeSubscription(t *testing.T) {
+	base := &Subscription{ID: 1, URL: "https://example.com/feed", Failures: 2, NextFetch: 100}
+
+	// a command changed the interval while the feed was fetched
+	current := *base
+	current.Interval = 30
+	current.NextFetch = 200
+
+	fetched := *base
+	fetched.Failures = 0
+	fetched.LastSuccess = 150
+	fetched.NextFetch = 160
+	fetched.Seen = SeenItems{"a": {First: 150}}
+
+	mergeSubscription(&current, base, &fetched)
+
+	assert.Equal(t, 30, current.Interval)
+	assert.Equal(t, int64(200), current.NextFetch)
+	assert.Equal(t, 0, current.Failures)
+	assert.Equal(t, int64(150), current.LastSuccess)
+	assert.Equal(t, fetched.Seen, current.Seen)
+
+	// fetching paused the subscription and no command touched it
+	current = *base
+	fetched = *base
+	fetched.Paused = true
+	mergeSubscription(&current, base, &fetched)
+	assert.True(t, current.Paused)
+}
+
+func TestModifySubscriptionsWithoutChanges(t *testing.T) {
+	api, store := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	// reading a channel without subscriptions stores nothing
+	p.ensureIds("channel", &SubscriptionList{})
+	assert.NoError(t, p.modifySubscriptions("channel", func(*SubscriptionList) error { return nil }))
+	assert.Nil(t, store.get(subscriptionKey("channel")))
+	assert.Nil(t, store.get(channelIndexKey))
+}
+
+func TestModifySubscriptionsStoresNextPoll(t *testing.T) {
+	api, store := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	next, err := p.getNextPoll("channel")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), next)
+
+	nextFetch := time.Now().Add(10 * time.Minute).Unix()
+	require.NoError(t, p.addSubscription("channel", &Subscription{ID: 1, URL: "https://example.com/feed", NextFetch: nextFetch}))
+	next, err = p.getNextPoll("channel")
+	require.NoError(t, err)
+	assert.Equal(t, nextFetch, next)
+
+	// the heartbeat doesn't read channels without subscriptions
+	require.NoError(t, p.modifySubscriptions("channel", func(subs *SubscriptionList) error {
+		subs.Subscriptions = nil
+		return nil
+	}))
+	assert.Nil(t, store.get(nextPollKey("channel")))
+}
+
+func TestModifySubscriptionsRetriesConflicts(t *testing.T) {
+	api, store := newKVTestAPI(map[string][]byte{schemaVersionKey: []byte(strconv.Itoa(schemaVersion))})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+	require.NoError(t, p.addSubscription("channel", &Subscription{ID: 1, URL: "https://example.com/a"}))
+
+	// another node subscribes between reading the list and storing it
+	concurrent := func(attempt int) {
+		list := &SubscriptionList{Subscriptions: []*Subscription{
+			{ID: 1, URL: "https://example.com/a"},
+			{ID: uint32(100 + attempt), URL: fmt.Sprintf("https://example.com/concurrent/%d", attempt)},
+		}}
+		value, err := json.Marshal(list)
+		require.NoError(t, err)
+		store.set(subscriptionKey("channel"), value)
+	}
+
+	attempts := 0
+	err := p.modifySubscriptions("channel", func(subs *SubscriptionList) error {
+		attempts++
+		if attempts == 1 {
+			concurrent(attempts)
+		}
+		subs.Subscriptions[0].Title = "Renamed"
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts)
+
+	// the retry started over from the concurrent write
+	subs, err := p.getSubscriptions("channel")
+	require.NoError(t, err)
+	require.Len(t, subs.Subscriptions, 2)
+	assert.Equal(t, "Renamed", subs.Subscriptions[0].Title)
+	assert.Equal(t, uint32(101), subs.Subscriptions[1].ID)
+
+	// a list that keeps changing gives up after kvSetAttempts
+	attempts = 0
+	err = p.modifySubscriptions("channel", func(subs *SubscriptionList) error {
+		attempts++
+		concurrent(attempts)
+		subs.Subscriptions[0].Title = "Lost"
+		return nil
+	})
+	assert.Equal(t, errKVConflict, err)
+	assert.Equal(t, kvSetAttempts, attempts)
+
+	subs, err = p.getSubscriptions("channel")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("https://example.com/concurrent/%d", kvSetAttempts), subs.Subscriptions[1].URL)
+	for _, sub := range subs.Subscriptions {
+		assert.NotEqual(t, "Lost", sub.Title)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Message templates
+
+Subscriptions can render their items with a Go text/template, set with /feed template
+or for all subscriptions without one with the MessageTemplate setting. The template sees a TemplateItem,
+its output replaces the description of the item, it can add fields to the item's attachment
+and write the message of the post:
+
+	{{message "New on" .Feed}}
+	{{truncate 200 .Summary}}
+	{{if .Author}}{{shortField "Author" .Author}}{{end}}
+	{{range .Enclosures}}{{field "Download" .URL}}{{end}}
+
+https://golang.org/pkg/text/template/
+*/
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// maxTemplateLength - templates are stored with the subscription
+const maxTemplateLength = 4000
+
+// TemplateItem - what templates see of an item
+type TemplateItem struct {
+	Title      string
+	Link       string
+	Author     string
+	Published  time.Time // zero if the feed doesn't date its items
+	Categories []string
+	Summary    string // description or content of the item as markdown
+	Enclosures []ItemEnclosure
+	Feed       string // title of the feed
+	Updated    bool   // the item changed after it was posted
+}
+
+// ItemEnclosure - a file attached to an item, such as a podcast episode
+type ItemEnclosure struct {
+	URL    string
+	Type   string // mime type
+	Title  string
+	Length int64 // bytes, 0 if unknown
+}
+
+// templateOutput - what a template rendered for an item
+type templateOutput struct {
+	text    string // replaces the description of the item
+	message []string
+	fields  []*model.SlackAttachmentField
+}
+
+func (o *templateOutput) funcs() template.FuncMap {
+	return template.FuncMap{
+		// message adds a line to the message of the post, the values are separated by spaces
+		"message": func(values ...interface{}) string {
+			o.message = append(o.message, strings.TrimSpace(fmt.Sprintln(values...)))
+			return ""
+		},
+		// field adds a field to the attachment
+		"field": func(title string, value interface{}) string {
+			o.fields = append(o.fields, &model.SlackAttachmentField{Title: title, Value: fmt.Sprint(value)})
+			return ""
+		},
+		// shortField adds a field shown next to other short fields
+		"shortField": func(title string, value interface{}) string {
+			o.fields = append(o.fields, &model.SlackAttachmentField{Title: title, Value: fmt.Sprint(value), Short: true})
+			return ""
+		},
+		"truncate": truncateText,
+		"join":     strings.Join,
+		"hashtags": func(categories []string) string {
+			items := make([]ItemCategory, len(categories))
+			for i, name := range categories {
+				items[i] = ItemCategory{Name: name}
+			}
+			return strings.Join(hashtags(items), " ")
+		},
+		"date": func(layout string, t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.UTC().Format(layout)
+		},
+	}
+}
+
+// truncateText shortens text to at most length runes, ending in an ellipsis if it was cut
+func truncateText(length int, text string) string {
+	runes := []rune(strings.TrimSpace(text))
+	if len(runes) <= length {
+		return string(runes)
+	}
+	if length < 1 {
+		return ""
+	}
+	return strings.TrimSpace(string(runes[:length-1])) + "…"
+}
+
+// itemTemplate - a parsed message template, parsed once and rendered for every item of a poll.
+// The template functions write to output, so items must be rendered one after another
+type itemTemplate struct {
+	tmpl   *template.Template
+	output *templateOutput
+}
+
+// parseMessageTemplate parses text with the functions available to templates
+func parseMessageTemplate(text string) (*itemTemplate, error) {
+	output := &templateOutput{}
+	tmpl, err := template.New("message").Funcs(output.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &itemTemplate{tmpl: tmpl, output: output}, nil
+}
+
+// render renders the item, the output holds what was rendered until an error
+func (t *itemTemplate) render(item *TemplateItem) (*templateOutput, error) {
+	*t.output = templateOutput{}
+
+	buffer := &bytes.Buffer{}
+	err := t.tmpl.Execute(buffer, item)
+	output := *t.output
+	output.text = strings.TrimSpace(buffer.String())
+	return &output, err
+}
+
+// renderTemplate parses text and renders the item
+func renderTemplate(text string, item *TemplateItem) (*templateOutput, error) {
+	tmpl, err := parseMessageTemplate(text)
+	if err != nil {
+		return &templateOutput{}, err
+	}
+	return tmpl.render(item)
+}
+
+// postMessage - the lines added with the message function
+func (o *templateOutput) postMessage() string {
+	return strings.TrimSpace(strings.Join(o.message, "\n"))
+}
+
+// sampleTemplateItem - the item templates are tried with before they are saved
+func sampleTemplateItem(feed string) *TemplateItem {
+	return &TemplateItem{
+		Title:      "Kubernetes 1.18 released",
+		Link:       "https://example.com/blog/kubernetes-1-18",
+		Author:     "Release Team",
+		Published:  time.Date(2020, 3, 25, 16, 0, 0, 0, time.UTC),
+		Categories: []string{"Kubernetes", "Release"},
+		Summary:    "We're pleased to announce the delivery of Kubernetes 1.18, our first release of 2020!",
+		Enclosures: []ItemEnclosure{{URL: "https://example.com/media/episode-42.mp3", Type: "audio/mpeg", Title: "Episode 42", Length: 12216320}},
+		Feed:       feed,
+	}
+}
+
+// validateMessageTemplate renders the sample item, returns the rendered message and
+// an error that includes what was rendered before it failed
+func validateMessageTemplate(text string, feed string) (string, error) {
+	if len(text) > maxTemplateLength {
+		return "", fmt.Errorf("the template is longer than %d characters", maxTemplateLength)
+	}
+
+	output, err := renderTemplate(text, sampleTemplateItem(feed))
+	sample := describeSample(output)
+	if err != nil {
+		if sample != "" {
+			return "", fmt.Errorf("%s\nRendered until the error:\n%s", err.Error(), sample)
+		}
+		return "", err
+	}
+	return sample, nil
+}
+
+// describeSample shows a rendered sample in markdown
+func describeSample(output *templateOutput) string {
+	lines := []string{}
+	if message := output.postMessage(); message != "" {
+		lines = append(lines, "Message:", quoteMarkdown(message), "Attachment:")
+	}
+	if output.text != "" {
+		lines = append(lines, quoteMarkdown(output.text))
+	}
+	for _, field := range output.fields {
+		lines = append(lines, fmt.Sprintf("> **%s**: %v", field.Title, field.Value))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// messageTemplate - the template of the subscription, or the default of the configuration
+func (s *Subscription) messageTemplate(config *configuration) string {
+	if s.Template != "" {
+		return s.Template
+	}
+	return config.MessageTemplate
+}
+
+// templateItem - the item as seen by templates
+func templateItem(sub *Subscription, item *FeedItem) *TemplateItem {
+	result := &TemplateItem{
+		Title:      item.Fields.Title,
+		Link:       item.Fields.Link,
+		Author:     item.Fields.Author,
+		Categories: categoryNames(item.Fields.Categories),
+		Summary:    item.Fields.Content,
+		Enclosures: item.Fields.Enclosures,
+		Feed:       sub.Title,
+		Updated:    item.Updated,
+	}
+	if timestamp, ok := item.Attachment.Timestamp.(int64); ok && timestamp > 0 {
+		result.Published = time.Unix(timestamp, 0).UTC()
+	}
+	return result
+}
+
+// loadTemplate parses the template of the subscription, nil if it has none or it is invalid
+func (p *RSSFeedPlugin) loadTemplate(sub *Subscription, config *configuration) *itemTemplate {
+	text := sub.messageTemplate(config)
+	if text == "" {
+		return nil
+	}
+
+	tmpl, err := parseMessageTemplate(text)
+	if err != nil {
+		p.API.LogError("Failed to parse message template", "subscription", sub.ID, "err", err.Error())
+		return nil
+	}
+	return tmpl
+}
+
+// applyTemplate renders the item with the template returned by loadTemplate,
+// the item keeps the default layout without a template or if it fails
+func (p *RSSFeedPlugin) applyTemplate(tmpl *itemTemplate, sub *Subscription, item *FeedItem) {
+	if tmpl == nil {
+		return
+	}
+
+	output, err := tmpl.render(templateItem(sub, item))
+	if err != nil {
+		p.API.LogError("Failed to render message template", "subscription", sub.ID, "err", err.Error())
+		return
+	}
+
+	item.Attachment.Text = output.text
+	item.Attachment.Fields = append(item.Attachment.Fields, output.fields...)
+	item.Message = output.postMessage()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRenderTemplate(t *testing.T) {
+	item := sampleTemplateItem("Kubernetes Blog")
+
+	output, err := renderTemplate(`{{message "New on" .Feed}}**{{.Feed}}**: {{truncate 20 .Summary}} {{hashtags .Categories}}
+{{shortField "Published" (date "Jan 2, 2006" .Published)}}{{range .Enclosures}}{{field "Download" .URL}}{{end}}`, item)
+	assert.NoError(t, err)
+	assert.Equal(t, "**Kubernetes Blog**: We're pleased to an… #kubernetes #release", output.text)
+	assert.Equal(t, "New on Kubernetes Blog", output.postMessage())
+	assert.Equal(t, []*model.SlackAttachmentField{
+		{Title: "Published", Value: "Mar 25, 2020", Short: true},
+		{Title: "Download", Value: "https://example.com/media/episode-42.mp3"},
+	}, output.fields)
+}
+
+func TestPostItemsWithTemplate(t *testing.T) {
+	posts := []*model.Post{}
+	api := &plugintest.API{}
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		posts = append(posts, post)
+		return post
+	}, nil)
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	sub := &Subscription{ID: 1, Title: "Kubernetes Blog", Template: `{{message "New on" .Feed}}{{.Title}}`}
+	item := &FeedItem{
+		Key:        "item",
+		Attachment: &model.SlackAttachment{Title: "Kubernetes 1.18", TitleLink: "https://example.com/1.18", Text: "Notes"},
+		Fields:     ItemFields{Title: "Kubernetes 1.18", Categories: []ItemCategory{{Name: "Release"}}},
+	}
+	p.postItems("channel", sub, []*FeedItem{item}, &configuration{ShowCategories: CategoriesHashtags})
+
+	require.Len(t, posts, 1)
+	assert.Equal(t, "New on Kubernetes Blog\n#release", posts[0].Message)
+	require.Len(t, posts[0].Attachments(), 1)
+	assert.Equal(t, "Kubernetes 1.18", posts[0].Attachments()[0].Text)
+}
+
+func TestValidateMessageTemplate(t *testing.T) {
+	sample, err := validateMessageTemplate("{{.Title}}", "Feed")
+	assert.NoError(t, err)
+	assert.Equal(t, "> Kubernetes 1.18 released", sample)
+
+	sample, err = validateMessageTemplate("{{message .Feed}}{{.Title}}", "Feed")
+	assert.NoError(t, err)
+	assert.Equal(t, "Message:\n> Feed\nAttachment:\n> Kubernetes 1.18 released", sample)
+
+	_, err = validateMessageTemplate("{{.Title", "Feed")
+	assert.Error(t, err)
+
+	// unknown fields fail when executed, the error shows what was rendered until then
+	_, err = validateMessageTemplate("{{.Title}} {{.Missing}}", "Feed")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "> Kubernetes 1.18 released")
+	}
+}
+
+func TestInvalidDefaultTemplate(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("LoadPluginConfiguration", mock.Anything).Run(func(args mock.Arguments) {
+		config := args.Get(0).(*configuration)
+		config.MessageTemplate = "{{.Title"
+		config.Heartbeat = "15"
+	}).Return(nil)
+	api.On("LogError", "Ignoring invalid message template", "err", mock.Anything).Return()
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	require.NoError(t, p.OnConfigurationChange())
+	assert.Equal(t, "", p.getConfiguration().MessageTemplate)
+	assert.Equal(t, "15", p.getConfiguration().Heartbeat)
+	api.AssertExpectations(t)
+}
+
+func TestItemTemplateRendersItemsSeparately(t *testing.T) {
+	tmpl, err := parseMessageTemplate(`{{message .Title}}{{field "Feed" .Feed}}{{.Summary}}`)
+	require.NoError(t, err)
+
+	first, err := tmpl.render(&TemplateItem{Title: "First", Feed: "Blog", Summary: "one"})
+	require.NoError(t, err)
+	second, err := tmpl.render(&TemplateItem{Title: "Second", Feed: "Blog", Summary: "two"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "First", first.postMessage())
+	assert.Equal(t, "one", first.text)
+	assert.Len(t, first.fields, 1)
+	assert.Equal(t, "Second", second.postMessage())
+	assert.Equal(t, "two", second.text)
+	assert.Len(t, second.fields, 1)
+}
+
+func TestHandleTemplateRequiresPermission(t *testing.T) {
+	api := &plugintest.API{}
+	api.On("GetChannel", "public").Return(&model.Channel{Id: "public", Type: model.CHANNEL_OPEN}, nil)
+	api.On("GetChannel", "private").Return(&model.Channel{Id: "private", Type: model.CHANNEL_PRIVATE}, nil)
+	api.On("HasPermissionToChannel", "user", "public", model.PERMISSION_MANAGE_PUBLIC_CHANNEL_PROPERTIES).Return(true)
+	api.On("HasPermissionToChannel", mock.Anything, mock.Anything, mock.Anything).Return(false)
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	assert.True(t, p.canManageChannel("user", "public"))
+	assert.False(t, p.canManageChannel("user", "private"))
+	assert.False(t, p.canManageChannel("other", "public"))
+
+	resp := p.handleTemplate("1", "{{.Title}}", &model.CommandArgs{UserId: "user", ChannelId: "private"})
+	assert.Contains(t, resp.Text, "permission")
+}
@@ -0,0 +1,123 @@
+/*
+Threads
+
+Busy feeds can post items as replies instead of flooding the channel, set with /feed thread.
+"feed" posts all items of the subscription under a single root post, "day" and "week" start a new root post
+every day or ISO week (UTC), its message names the feed and the period.
+The id of the current root post is stored with the subscription, if the root post was deleted a new one is started.
+A root post is only kept once an item was posted in it.
+*/
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// thread modes
+const (
+	ThreadOff  = "off"
+	ThreadFeed = "feed"
+	ThreadDay  = "day"
+	ThreadWeek = "week"
+)
+
+func isThreadMode(mode string) bool {
+	return mode == ThreadOff || mode == ThreadFeed || mode == ThreadDay || mode == ThreadWeek
+}
+
+// ThreadMode - whether items are posted as replies, off unless set otherwise
+func (s *Subscription) ThreadMode() string {
+	if s.Thread == "" {
+		return ThreadOff
+	}
+	return s.Thread
+}
+
+// threadPeriod - the period a root post is used for, changing the mode changes the period too
+func threadPeriod(mode string, now time.Time) string {
+	now = now.UTC()
+	switch mode {
+	case ThreadDay:
+		return now.Format("2006-01-02")
+	case ThreadWeek:
+		year, week := now.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+	return mode
+}
+
+// threadHeader - the message of a root post
+func threadHeader(sub *Subscription, mode string, now time.Time) string {
+	now = now.UTC()
+	switch mode {
+	case ThreadDay:
+		return fmt.Sprintf("#### %s on %s", sub.markdownLink(), now.Format("Monday, January 2, 2006"))
+	case ThreadWeek:
+		// ISO weeks start on monday
+		start := now.AddDate(0, 0, -(int(now.Weekday())+6)%7)
+		end := start.AddDate(0, 0, 6)
+		return fmt.Sprintf("#### %s, week of %s to %s", sub.markdownLink(), start.Format("January 2"), end.Format("January 2, 2006"))
+	}
+	return fmt.Sprintf("#### %s", sub.markdownLink())
+}
+
+// threadRoot returns the id of the post items are replies to, posting a new root post when the period changed,
+// empty if items are posted to the channel. created is true if the root post was just posted
+func (p *RSSFeedPlugin) threadRoot(channelID string, sub *Subscription, now time.Time) (string, bool) {
+	mode := sub.ThreadMode()
+	if mode == ThreadOff {
+		return "", false
+	}
+
+	period := threadPeriod(mode, now)
+	if sub.ThreadRoot != "" && sub.ThreadPeriod == period {
+		return sub.ThreadRoot, false
+	}
+
+	post := p.createBotPost(threadHeader(sub, mode, now), channelID, "", "", nil)
+	if post == nil {
+		// the items are posted to the channel instead
+		return "", false
+	}
+	sub.ThreadRoot = post.Id
+	sub.ThreadPeriod = period
+	return post.Id, true
+}
+
+// createItemPost posts items of the subscription, in its thread if it has one,
+// returns the created post or nil if creating it failed
+func (p *RSSFeedPlugin) createItemPost(channelID string, sub *Subscription, msg string, attachments []*model.SlackAttachment) *model.Post {
+	now := time.Now()
+	rootID, created := p.threadRoot(channelID, sub, now)
+	post := p.createReply(channelID, sub, msg, rootID, created, attachments)
+	if post != nil || rootID == "" || created {
+		return post
+	}
+
+	// replies to a deleted root post fail, start a new thread
+	if _, appErr := p.API.GetPost(rootID); appErr == nil {
+		return nil
+	}
+	sub.ThreadRoot = ""
+	rootID, created = p.threadRoot(channelID, sub, now)
+	return p.createReply(channelID, sub, msg, rootID, created, attachments)
+}
+
+// createReply posts items as a reply to rootID, a root post created for them is deleted again if that fails
+// so no thread without items is left behind, the next items start it again
+func (p *RSSFeedPlugin) createReply(channelID string, sub *Subscription, msg string, rootID string, created bool, attachments []*model.SlackAttachment) *model.Post {
+	post := p.createBotPost(msg, channelID, "", rootID, attachments)
+	if post != nil || !created {
+		return post
+	}
+
+	if appErr := p.API.DeletePost(rootID); appErr != nil {
+		p.API.LogError("Failed to delete empty thread root post", "post_id", rootID, "err", appErr.Error())
+	}
+	sub.ThreadRoot = ""
+	return nil
+}
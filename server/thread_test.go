@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestThreadPeriod(t *testing.T) {
+	// a sunday, still in week 53 of 2020
+	now := time.Date(2021, 1, 3, 23, 30, 0, 0, time.UTC)
+	assert.Equal(t, ThreadFeed, threadPeriod(ThreadFeed, now))
+	assert.Equal(t, "2021-01-03", threadPeriod(ThreadDay, now))
+	assert.Equal(t, "2020-W53", threadPeriod(ThreadWeek, now))
+	assert.Equal(t, "2021-W01", threadPeriod(ThreadWeek, now.Add(time.Hour)))
+
+	// periods are in UTC
+	assert.Equal(t, "2021-01-03", threadPeriod(ThreadDay, now.In(time.FixedZone("UTC+2", 2*60*60))))
+}
+
+func TestThreadHeader(t *testing.T) {
+	sub := &Subscription{Title: "Releases", URL: "https://example.com/feed"}
+	now := time.Date(2021, 1, 3, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "#### [Releases](https://example.com/feed)", threadHeader(sub, ThreadFeed, now))
+	assert.Equal(t, "#### [Releases](https://example.com/feed) on Sunday, January 3, 2021", threadHeader(sub, ThreadDay, now))
+	assert.Equal(t, "#### [Releases](https://example.com/feed), week of December 28 to January 3, 2021", threadHeader(sub, ThreadWeek, now))
+	assert.Equal(t, "#### [Releases](https://example.com/feed), week of January 4 to January 10, 2021", threadHeader(sub, ThreadWeek, now.AddDate(0, 0, 1)))
+}
+
+// newThreadTestAPI collects the created posts, posts are rejected while fail returns true
+func newThreadTestAPI(fail func(post *model.Post) bool) (*plugintest.API, *[]*model.Post) {
+	posts := []*model.Post{}
+	api := &plugintest.API{}
+	api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+		if fail(post) {
+			return nil
+		}
+		post.Id = fmt.Sprintf("post%d", len(posts)+1)
+		posts = append(posts, post)
+		return post
+	}, func(post *model.Post) *model.AppError {
+		if fail(post) {
+			return &model.AppError{Message: "failed to create post"}
+		}
+		return nil
+	})
+	api.On("LogError", mock.Anything).Return()
+	api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	return api, &posts
+}
+
+func TestCreateItemPostStartsThreads(t *testing.T) {
+	api, posts := newThreadTestAPI(func(*model.Post) bool { return false })
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	sub := &Subscription{Title: "Releases", URL: "https://example.com/feed", Thread: ThreadDay}
+	first := p.createItemPost("channel", sub, "first", nil)
+	require.NotNil(t, first)
+	second := p.createItemPost("channel", sub, "second", nil)
+	require.NotNil(t, second)
+
+	// one root post with the header, both items reply to it
+	require.Len(t, *posts, 3)
+	root := (*posts)[0]
+	assert.Equal(t, threadHeader(sub, ThreadDay, time.Now()), root.Message)
+	assert.Empty(t, root.RootId)
+	assert.Equal(t, root.Id, first.RootId)
+	assert.Equal(t, root.Id, second.RootId)
+	assert.Equal(t, root.Id, sub.ThreadRoot)
+	assert.Equal(t, threadPeriod(ThreadDay, time.Now()), sub.ThreadPeriod)
+
+	// without a thread items are posted to the channel
+	sub.Thread = ThreadOff
+	post := p.createItemPost("channel", sub, "third", nil)
+	require.NotNil(t, post)
+	assert.Empty(t, post.RootId)
+}
+
+func TestCreateItemPostRestartsDeletedThreads(t *testing.T) {
+	// replies to the deleted root post are rejected
+	api, posts := newThreadTestAPI(func(post *model.Post) bool { return post.RootId == "deleted" })
+	api.On("GetPost", "deleted").Return(nil, &model.AppError{Message: "not found"})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	sub := &Subscription{Title: "Releases", URL: "https://example.com/feed", Thread: ThreadFeed, ThreadRoot: "deleted", ThreadPeriod: ThreadFeed}
+	post := p.createItemPost("channel", sub, "item", nil)
+	require.NotNil(t, post)
+
+	require.Len(t, *posts, 2)
+	root := (*posts)[0]
+	assert.Equal(t, "#### [Releases](https://example.com/feed)", root.Message)
+	assert.Equal(t, root.Id, post.RootId)
+	assert.Equal(t, root.Id, sub.ThreadRoot)
+}
+
+func TestCreateItemPostDeletesEmptyThreads(t *testing.T) {
+	// the root post is created but the items can't be posted
+	api, posts := newThreadTestAPI(func(post *model.Post) bool { return post.RootId != "" })
+	api.On("DeletePost", "post1").Return(nil)
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	sub := &Subscription{Title: "Releases", URL: "https://example.com/feed", Thread: ThreadWeek}
+	assert.Nil(t, p.createItemPost("channel", sub, "item", nil))
+
+	require.Len(t, *posts, 1)
+	api.AssertCalled(t, "DeletePost", "post1")
+	assert.Empty(t, sub.ThreadRoot)
+}
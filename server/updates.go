@@ -0,0 +1,236 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mattermost/mattermost-server/model"
+)
+
+// what happens when an item that has already been posted changes
+const (
+	UpdateModeEdit   = "edit"   // edit the original post
+	UpdateModeRepost = "repost" // post the item again
+	UpdateModeIgnore = "ignore" // keep the original post as it is
+)
+
+// updatedMarker is set as the footer of edited attachments
+const updatedMarker = "Updated"
+
+var errPostDeleted = errors.New("the post of the item was deleted")
+
+func isUpdateMode(mode string) bool {
+	return mode == UpdateModeEdit || mode == UpdateModeRepost || mode == UpdateModeIgnore
+}
+
+// UpdateMode - how changes to posted items are handled, edit unless set otherwise
+func (s *Subscription) UpdateMode() string {
+	if s.Updates == "" {
+		return UpdateModeEdit
+	}
+	return s.Updates
+}
+
+// postItems posts new items and handles changed ones according to the update mode of the subscription,
+// items dropped by the subscription's filters are neither posted nor edited,
+// the caller is responsible for storing the subscription
+func (p *RSSFeedPlugin) postItems(channelID string, sub *Subscription, items []*FeedItem, config *configuration) {
+	newItems := []*FeedItem{}
+	tmpl := p.loadTemplate(sub, config)
+	for _, item := range sub.filterItems(items) {
+		showCategories(item, config)
+		p.applyTemplate(tmpl, sub, item)
+
+		if !item.Updated {
+			newItems = append(newItems, item)
+			continue
+		}
+
+		switch sub.UpdateMode() {
+		case UpdateModeRepost:
+			newItems = append(newItems, item)
+		case UpdateModeEdit:
+			if item.PostID == "" {
+				// posted before post ids were tracked, there is nothing to edit
+				continue
+			}
+			err := p.editItemPost(item, config)
+			if err == errPostDeleted {
+				// there is nothing left to edit, post the item again
+				newItems = append(newItems, item)
+			} else if err != nil {
+				p.API.LogError("Failed to edit post of updated item", "post_id", item.PostID, "err", err.Error())
+			}
+		}
+	}
+
+	if len(newItems) == 0 {
+		return
+	}
+
+	if config.SortMessages {
+		sort.Slice(newItems, func(i, j int) bool {
+			return newItems[i].Attachment.Timestamp.(int64) < newItems[j].Attachment.Timestamp.(int64)
+		})
+	}
+
+	switch sub.PostLayout() {
+	case LayoutMarkdown:
+		p.postMarkdown(channelID, sub, newItems, config)
+	case LayoutCompact:
+		p.postCompact(channelID, sub, newItems, config)
+	default:
+		p.postAttachments(channelID, sub, newItems, config)
+	}
+}
+
+// groups the attachments of new items according to the configuration and posts them
+func (p *RSSFeedPlugin) postAttachments(channelID string, sub *Subscription, items []*FeedItem, config *configuration) {
+	var err error
+
+	itemsByAttachment := make(map[*model.SlackAttachment]*FeedItem, len(items))
+	attachments := make([]*model.SlackAttachment, len(items))
+	for index, item := range items {
+		itemsByAttachment[item.Attachment] = item
+		attachments[index] = item.Attachment
+	}
+
+	// Send as separate messages or group as few messages as possible
+	var groupedAttachments [][]*model.SlackAttachment
+	if config.GroupMessages {
+		groupedAttachments, err = p.groupAttachments(attachments)
+		if err != nil {
+			p.API.LogError(err.Error())
+			return
+		}
+	} else {
+		groupedAttachments = p.padAttachments(attachments)
+	}
+
+	for _, group := range groupedAttachments {
+		groupItems := make([]*FeedItem, len(group))
+		for index, attachment := range group {
+			groupItems[index] = itemsByAttachment[attachment]
+		}
+
+		post := p.createItemPost(channelID, sub, attachmentsMessage(groupItems, config), group)
+		if post == nil {
+			continue
+		}
+		for index, item := range groupItems {
+			sub.markPosted(item.Key, post.Id, index, LayoutAttachment)
+		}
+	}
+}
+
+// attachmentsMessage - the message of a post with the attachments of items,
+// the messages rendered by their template followed by their hashtags
+func attachmentsMessage(items []*FeedItem, config *configuration) string {
+	lines := []string{}
+	for _, item := range items {
+		if item.Message != "" {
+			lines = append(lines, item.Message)
+		}
+	}
+	if tags := hashtagMessage(items, config); tags != "" {
+		lines = append(lines, tags)
+	}
+	return truncateRunes(strings.Join(lines, "\n"), model.POST_MESSAGE_MAX_RUNES_V2)
+}
+
+// editItemPost replaces the attachment of the item in the post it was posted in,
+// returns errPostDeleted if that post doesn't exist anymore
+func (p *RSSFeedPlugin) editItemPost(item *FeedItem, config *configuration) error {
+	post, appErr := p.API.GetPost(item.PostID)
+	if appErr != nil {
+		if appErr.StatusCode == http.StatusNotFound {
+			return errPostDeleted
+		}
+		return appErr
+	}
+	if post.DeleteAt != 0 {
+		return errPostDeleted
+	}
+
+	// the previous revision is only known for attachments
+	var previous *model.SlackAttachment
+	if item.Layout == LayoutAttachment {
+		attachments := post.Attachments()
+		if item.Index < 0 || item.Index >= len(attachments) {
+			return fmt.Errorf("post has no attachment %d", item.Index)
+		}
+
+		previous = attachments[item.Index]
+		if config.MarkUpdates {
+			item.Attachment.Footer = updatedMarker
+		}
+		attachments[item.Index] = item.Attachment
+		post.AddProp("attachments", attachments)
+		if len(attachments) == 1 {
+			post.Message = attachmentsMessage([]*FeedItem{item}, config)
+		} else {
+			// the messages of the other items aren't known anymore
+			addHashtags(post, hashtagMessage([]*FeedItem{item}, config))
+		}
+	} else if err := editMessage(post, item, config); err != nil {
+		return err
+	}
+
+	if _, appErr := p.API.UpdatePost(post); appErr != nil {
+		return appErr
+	}
+
+	if config.ReplyToUpdates {
+		// items posted in a thread are replies themselves
+		rootID := post.RootId
+		if rootID == "" {
+			rootID = post.Id
+		}
+		reply := &model.Post{
+			UserId:    p.botUserID,
+			ChannelId: post.ChannelId,
+			RootId:    rootID,
+			Message:   describeChanges(previous, item.Attachment),
+		}
+		if _, appErr := p.API.CreatePost(reply); appErr != nil {
+			return appErr
+		}
+	}
+
+	return nil
+}
+
+// describeChanges summarizes the differences between two revisions of an item in markdown,
+// previous is nil if it isn't known
+func describeChanges(previous *model.SlackAttachment, current *model.SlackAttachment) string {
+	if previous == nil {
+		return "This item was updated."
+	}
+
+	changes := []string{}
+	if previous.Title != current.Title {
+		changes = append(changes, fmt.Sprintf("* Title changed from ~~%s~~ to **%s**", previous.Title, current.Title))
+	}
+	if previous.TitleLink != current.TitleLink {
+		changes = append(changes, fmt.Sprintf("* Link changed to %s", current.TitleLink))
+	}
+	if previous.Text != current.Text {
+		changes = append(changes, "* Content changed:\n"+quoteMarkdown(current.Text))
+	}
+
+	if len(changes) == 0 {
+		return "This item was updated."
+	}
+	return "This item was updated:\n" + strings.Join(changes, "\n")
+}
+
+func quoteMarkdown(text string) string {
+	lines := strings.Split(strings.TrimSpace(text), "\n")
+	for index, line := range lines {
+		lines[index] = "> " + line
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/mattermost/mattermost-server/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPostItemsEditsUpdatedItems(t *testing.T) {
+	original := func() *model.Post {
+		post := &model.Post{Id: "post", ChannelId: "channel", Message: "Old message"}
+		post.AddProp("attachments", []*model.SlackAttachment{
+			{Title: "Release", TitleLink: "https://example.com/release", Text: "Notes"},
+		})
+		return post
+	}
+
+	for name, tc := range map[string]struct {
+		post      *model.Post
+		getErr    *model.AppError
+		config    *configuration
+		edited    bool
+		reposted  bool
+		replyText string
+	}{
+		"edit": {
+			post:   original(),
+			config: &configuration{},
+			edited: true,
+		},
+		"edit and mark": {
+			post:   original(),
+			config: &configuration{MarkUpdates: true},
+			edited: true,
+		},
+		"edit and reply": {
+			post:      original(),
+			config:    &configuration{ReplyToUpdates: true},
+			edited:    true,
+			replyText: "This item was updated:\n* Title changed from ~~Release~~ to **Release, edited**",
+		},
+		"deleted post": {
+			getErr:   &model.AppError{Id: "app.post.get.app_error", StatusCode: http.StatusNotFound},
+			config:   &configuration{ReplyToUpdates: true},
+			reposted: true,
+		},
+		"post marked as deleted": {
+			post:     &model.Post{Id: "post", ChannelId: "channel", DeleteAt: 1},
+			config:   &configuration{ReplyToUpdates: true},
+			reposted: true,
+		},
+		"failed to load the post": {
+			getErr: &model.AppError{Id: "app.post.get.app_error", StatusCode: http.StatusInternalServerError},
+			config: &configuration{},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			updated := []*model.Post{}
+			created := []*model.Post{}
+			api := &plugintest.API{}
+			api.On("GetPost", "post").Return(tc.post, tc.getErr)
+			api.On("UpdatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+				updated = append(updated, post)
+				return post
+			}, nil)
+			api.On("CreatePost", mock.Anything).Return(func(post *model.Post) *model.Post {
+				created = append(created, post)
+				post.Id = "new"
+				return post
+			}, nil)
+			api.On("LogError", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+			p := &RSSFeedPlugin{}
+			p.SetAPI(api)
+
+			sub := &Subscription{ID: 1, Title: "Releases", Seen: SeenItems{"item": {First: 1, PostID: "post"}}}
+			item := &FeedItem{
+				Key:        "item",
+				Updated:    true,
+				PostID:     "post",
+				Layout:     LayoutAttachment,
+				Attachment: &model.SlackAttachment{Title: "Release, edited", TitleLink: "https://example.com/release", Text: "Notes"},
+			}
+			p.postItems("channel", sub, []*FeedItem{item}, tc.config)
+
+			if !tc.edited {
+				assert.Empty(t, updated)
+			} else {
+				require.Len(t, updated, 1)
+				attachments := updated[0].Attachments()
+				require.Len(t, attachments, 1)
+				assert.Equal(t, "Release, edited", attachments[0].Title)
+				assert.Equal(t, "", updated[0].Message)
+				if tc.config.MarkUpdates {
+					assert.Equal(t, updatedMarker, attachments[0].Footer)
+				} else {
+					assert.Empty(t, attachments[0].Footer)
+				}
+			}
+
+			switch {
+			case tc.reposted:
+				require.Len(t, created, 1)
+				assert.Empty(t, created[0].RootId)
+				assert.Equal(t, "Release, edited", created[0].Attachments()[0].Title)
+				assert.Equal(t, "new", sub.Seen["item"].PostID)
+			case tc.replyText != "":
+				require.Len(t, created, 1)
+				assert.Equal(t, "post", created[0].RootId)
+				assert.Equal(t, tc.replyText, created[0].Message)
+			default:
+				assert.Empty(t, created)
+			}
+		})
+	}
+}
+
+func TestDescribeChanges(t *testing.T) {
+	previous := &model.SlackAttachment{Title: "Release", TitleLink: "https://example.com/release", Text: "Notes"}
+
+	for name, tc := range map[string]struct {
+		previous *model.SlackAttachment
+		current  model.SlackAttachment
+		expected string
+	}{
+		"unknown previous revision": {
+			current:  *previous,
+			expected: "This item was updated.",
+		},
+		"nothing visible changed": {
+			previous: previous,
+			current:  model.SlackAttachment{Title: "Release", TitleLink: "https://example.com/release", Text: "Notes", Footer: updatedMarker},
+			expected: "This item was updated.",
+		},
+		"title": {
+			previous: previous,
+			current:  model.SlackAttachment{Title: "Release 1.1", TitleLink: "https://example.com/release", Text: "Notes"},
+			expected: "This item was updated:\n* Title changed from ~~Release~~ to **Release 1.1**",
+		},
+		"everything": {
+			previous: previous,
+			current:  model.SlackAttachment{Title: "Release 1.1", TitleLink: "https://example.com/release-1.1", Text: "New notes\nand fixes\n"},
+			expected: "This item was updated:\n" +
+				"* Title changed from ~~Release~~ to **Release 1.1**\n" +
+				"* Link changed to https://example.com/release-1.1\n" +
+				"* Content changed:\n> New notes\n> and fixes",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			current := tc.current
+			assert.Equal(t, tc.expected, describeChanges(tc.previous, &current))
+		})
+	}
+}
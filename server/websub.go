@@ -0,0 +1,260 @@
+/*
+WebSub (formerly PubSubHubbub) push subscriptions
+https://www.w3.org/TR/websub/
+
+Feeds advertising a hub are subscribed to with a callback under ServeHTTP,
+pushed content is processed like a polled feed. Polling continues as a fallback
+in case the hub stops delivering.
+*/
+
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1" //nolint required by the WebSub spec
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// hub.mode values and timings of WebSub subscriptions
+const (
+	WebSubModeSubscribe   = "subscribe"
+	WebSubModeUnsubscribe = "unsubscribe"
+	WebSubModeDenied      = "denied"
+
+	// WebSubLeaseSeconds is the lease requested from hubs, they are free to pick another one
+	WebSubLeaseSeconds = 10 * 24 * 60 * 60
+
+	// renew leases a day before they expire
+	webSubRenewBefore = 24 * 60 * 60
+	// wait this long for a verification before asking the hub again
+	webSubRetryAfter = 60 * 60
+	// upper limit for the size of pushed content
+	webSubMaxBodySize = 10 * 1024 * 1024
+)
+
+// newWebSubSecret returns a random secret for the hub to sign pushed content with
+func newWebSubSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (p *RSSFeedPlugin) webSubCallbackURL(channelID string, id uint32) string {
+	return fmt.Sprintf("%s/websub/%s/%d", p.getURL(), channelID, id)
+}
+
+func (p *RSSFeedPlugin) requestWebSub(ctx context.Context, channelID string, sub *Subscription, mode string) {
+	callback := p.webSubCallbackURL(channelID, sub.ID)
+	err := p.RequestWebSub(withFetchPool(ctx, p.getFetchPool()), sub.Hub, mode, sub.Topic, callback, sub.HubSecret)
+	if err != nil {
+		p.API.LogError("WebSub request failed", "mode", mode, "topic", sub.Topic, "err", err.Error())
+	}
+}
+
+// ensureWebSub (re)subscribes to the hub when the lease is about to run out,
+// the caller is responsible for storing the subscription
+func (p *RSSFeedPlugin) ensureWebSub(ctx context.Context, channelID string, sub *Subscription) {
+	// without a secret pushed content can't be verified
+	if sub.Hub == "" || sub.HubSecret == "" {
+		return
+	}
+
+	now := time.Now().Unix()
+	if sub.HubLeaseEnd-now > webSubRenewBefore || now-sub.HubRequested < webSubRetryAfter {
+		return
+	}
+
+	sub.HubRequested = now
+	p.requestWebSub(ctx, channelID, sub, WebSubModeSubscribe)
+}
+
+// handles /websub/{channelID}/{subscriptionID}
+func (p *RSSFeedPlugin) handleHTTPWebSub(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/websub/"), "/")
+	if len(parts) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+
+	channelID := parts[0]
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		p.handleWebSubVerification(w, r, channelID, uint32(id))
+	case http.MethodPost:
+		p.handleWebSubContent(w, r, channelID, uint32(id))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *RSSFeedPlugin) handleWebSubVerification(w http.ResponseWriter, r *http.Request, channelID string, id uint32) {
+	params := r.URL.Query()
+	mode := params.Get("hub.mode")
+	topic := params.Get("hub.topic")
+	challenge := params.Get("hub.challenge")
+
+	switch mode {
+	case WebSubModeSubscribe:
+		lease := webSubLease(params.Get("hub.lease_seconds"))
+
+		err := p.updateSubscription(channelID, id, func(sub *Subscription) error {
+			if sub.Hub == "" || sub.Topic != topic {
+				return fmt.Errorf("unexpected topic %s", topic)
+			}
+			sub.HubLeaseEnd = time.Now().Unix() + lease
+			return nil
+		})
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+	case WebSubModeUnsubscribe:
+		// only confirm if the subscription is really gone
+		subs, err := p.getSubscriptions(channelID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if sub, _ := subs.findID(id); sub != nil && sub.Hub != "" {
+			http.NotFound(w, r)
+			return
+		}
+
+	case WebSubModeDenied:
+		p.API.LogWarn("WebSub subscription denied by hub", "topic", topic, "reason", params.Get("hub.reason"))
+		w.WriteHeader(http.StatusOK)
+		return
+
+	default:
+		http.Error(w, "invalid hub.mode", http.StatusBadRequest)
+		return
+	}
+
+	_, writeErr := w.Write([]byte(challenge))
+	if writeErr != nil {
+		p.API.LogError(writeErr.Error())
+	}
+}
+
+// webSubLease - the lease granted by the hub in seconds, never longer than the one requested
+// so the subscription is renewed in time, the requested lease if the hub sent none or an invalid one
+func webSubLease(param string) int64 {
+	lease, err := strconv.ParseInt(param, 10, 64)
+	if err != nil || lease <= 0 || lease > WebSubLeaseSeconds {
+		return WebSubLeaseSeconds
+	}
+	return lease
+}
+
+func (p *RSSFeedPlugin) handleWebSubContent(w http.ResponseWriter, r *http.Request, channelID string, id uint32) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, webSubMaxBodySize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	subs, err := p.getSubscriptions(channelID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	sub, _ := subs.findID(id)
+	if sub == nil || sub.Hub == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	// the hub must get a 2xx even if the signature is wrong, the content is ignored though
+	w.WriteHeader(http.StatusAccepted)
+
+	if sub.HubSecret == "" || !validWebSubSignature(r.Header.Get("X-Hub-Signature"), sub.HubSecret, body) {
+		p.API.LogWarn("Ignoring WebSub content without a valid signature", "topic", sub.Topic)
+		return
+	}
+
+	p.goWork(func(ctx context.Context) {
+		p.processPushedContent(ctx, channelID, id, string(body))
+	})
+}
+
+// processPushedContent is processSubscription for content delivered by a hub
+func (p *RSSFeedPlugin) processPushedContent(ctx context.Context, channelID string, id uint32, body string) {
+	config := p.getConfiguration()
+
+	var err error
+	lockErr := p.withChannelLock(ctx, channelID, channelLockWait, func(context.Context) {
+		err = p.fetchSubscription(channelID, id, func(sub *Subscription) error {
+			if sub.Paused {
+				// the hub keeps pushing until the lease runs out
+				return nil
+			}
+
+			oldURL := sub.URL
+			items, err := p.processFeedBody(ctx, sub, body, config)
+			if err != nil {
+				return err
+			}
+			sub.recordArrivals(items, time.Now())
+			p.notifyMoved(channelID, sub, oldURL)
+			p.postItems(channelID, sub, items, config)
+			return nil
+		})
+	})
+
+	if lockErr != nil {
+		err = lockErr
+	}
+	if err != nil {
+		p.API.LogError(err.Error())
+	}
+}
+
+// validWebSubSignature checks the X-Hub-Signature header, which has the form method=signature
+func validWebSubSignature(header string, secret string, body []byte) bool {
+	parts := strings.SplitN(header, "=", 2)
+	if len(parts) != 2 {
+		return false
+	}
+
+	var newHash func() hash.Hash
+	switch parts[0] {
+	case "sha1":
+		newHash = sha1.New
+	case "sha256":
+		newHash = sha256.New
+	case "sha384":
+		newHash = sha512.New384
+	case "sha512":
+		newHash = sha512.New
+	default:
+		return false
+	}
+
+	mac := hmac.New(newHash, []byte(secret))
+	_, _ = mac.Write(body)
+	expected := hex.EncodeToString(mac.Sum(nil))
+
+	return hmac.Equal([]byte(expected), []byte(strings.ToLower(parts[1])))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mattermost/mattermost-server/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidWebSubSignature(t *testing.T) {
+	body := []byte("<feed></feed>")
+	secret := "secret"
+
+	// echo -n '<feed></feed>' | openssl dgst -sha256 -hmac secret
+	assert.True(t, validWebSubSignature("sha256=0cad5c88819dd0e1e833ec98bdc76a8990a05408a0795b1427d49b392cd74d54", secret, body))
+	assert.True(t, validWebSubSignature("sha1=87695bea5c072947a930fa324488bd09f7483880", secret, body))
+	assert.False(t, validWebSubSignature("sha1=87695bea5c072947a930fa324488bd09f7483880", "other", body))
+	assert.False(t, validWebSubSignature("md5=87695bea5c072947a930fa324488bd09", secret, body))
+	assert.False(t, validWebSubSignature("", secret, body))
+}
+
+func TestWebSubLease(t *testing.T) {
+	for param, expected := range map[string]int64{
+		"3600":                               3600,
+		strconv.Itoa(WebSubLeaseSeconds):     WebSubLeaseSeconds,
+		strconv.Itoa(WebSubLeaseSeconds + 1): WebSubLeaseSeconds,
+		"31536000000":                        WebSubLeaseSeconds,
+		"0":                                  WebSubLeaseSeconds,
+		"-5":                                 WebSubLeaseSeconds,
+		"a day":                              WebSubLeaseSeconds,
+		"":                                   WebSubLeaseSeconds,
+	} {
+		assert.Equal(t, expected, webSubLease(param), param)
+	}
+}
+
+func TestHandleWebSubVerificationClampsLease(t *testing.T) {
+	channelID := model.NewId()
+	list := &SubscriptionList{Subscriptions: []*Subscription{}}
+	list.addpend(&Subscription{URL: "https://example.com/feed", ID: 1, Hub: "https://hub.example.com", Topic: "https://example.com/feed"})
+	value, err := json.Marshal(list)
+	require.NoError(t, err)
+
+	api, _ := newKVTestAPI(map[string][]byte{
+		schemaVersionKey:           []byte(strconv.Itoa(schemaVersion)),
+		subscriptionKey(channelID): value,
+	})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	// a hub granting a year would keep the subscription from being renewed
+	query := "?hub.mode=subscribe&hub.topic=https://example.com/feed&hub.challenge=abc&hub.lease_seconds=31536000"
+	r := httptest.NewRequest(http.MethodGet, "/websub/"+channelID+"/1"+query, nil)
+	w := httptest.NewRecorder()
+	p.handleWebSubVerification(w, r, channelID, 1)
+	assert.Equal(t, "abc", w.Body.String())
+
+	subs, err := p.getSubscriptions(channelID)
+	require.NoError(t, err)
+	assert.True(t, subs.Subscriptions[0].HubLeaseEnd <= time.Now().Unix()+WebSubLeaseSeconds)
+	assert.True(t, subs.Subscriptions[0].HubLeaseEnd > time.Now().Unix())
+}
+
+func TestHandleWebSubContentRequiresSecret(t *testing.T) {
+	channelID := model.NewId()
+	list := &SubscriptionList{Subscriptions: []*Subscription{}}
+	list.addpend(&Subscription{URL: "https://example.com/feed", ID: 1, Hub: "https://hub.example.com", Topic: "https://example.com/feed"})
+	value, err := json.Marshal(list)
+	require.NoError(t, err)
+
+	api, _ := newKVTestAPI(map[string][]byte{
+		schemaVersionKey:           []byte(strconv.Itoa(schemaVersion)),
+		subscriptionKey(channelID): value,
+	})
+	p := &RSSFeedPlugin{}
+	p.SetAPI(api)
+
+	// without a secret unsigned content would be trusted
+	r := httptest.NewRequest(http.MethodPost, "/websub/"+channelID+"/1", strings.NewReader("<feed></feed>"))
+	w := httptest.NewRecorder()
+	p.handleWebSubContent(w, r, channelID, 1)
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	api.AssertCalled(t, "LogWarn", "Ignoring WebSub content without a valid signature", "topic", "https://example.com/feed")
+}
+
+func TestRequestWebSubUsesContext(t *testing.T) {
+	var requested *http.Request
+	handler := FeedHandlerDefault{client: clientFunc(func(req *http.Request) (*http.Response, error) {
+		requested = req
+		return nil, req.Context().Err()
+	})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := handler.RequestWebSub(ctx, "https://hub.example.com", WebSubModeSubscribe, "https://example.com/feed", "https://mattermost.example.com/websub/channel/1", "secret")
+	assert.Equal(t, context.Canceled, err)
+	require.NotNil(t, requested)
+	assert.Equal(t, "secret", requested.FormValue("hub.secret"))
+}